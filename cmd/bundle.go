@@ -0,0 +1,315 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// Bundle executes the bundle command
+func Bundle(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("Usage: git-lfs-webdav bundle <create|import> [args...]")
+	}
+
+	switch args[0] {
+	case "create":
+		return bundleCreate(args[1:])
+	case "import":
+		return bundleImport(args[1:])
+	}
+
+	return fmt.Errorf("Unknown bundle command %q", args[0])
+}
+
+func bundleCreate(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("Usage: git-lfs-webdav bundle create <file> <git-rev-list-args...>")
+	}
+
+	file := args[0]
+
+	gitPath, err := internal.GitGetPath()
+	if err != nil {
+		return err
+	}
+
+	// The same arguments as for 'git bundle create' select the commits (e.g. "--all" or "v1.0..main")
+	pointers, err := internal.GitLFSPointers(args[1:]...)
+	if err != nil {
+		return err
+	}
+
+	// Objects which aren't available locally are read from the server (if it can be reached)
+	var router *internal.Router
+	remoteObjects := make(map[string]bool)
+	missing := make([]string, 0)
+	var total int64
+
+	for _, p := range pointers {
+		total += p.Size
+
+		if info, err := os.Stat(internal.LocalObjectPath(gitPath, p.Oid)); err == nil && info.Size() == p.Size {
+			continue
+		}
+
+		if router == nil {
+			router, err = internal.OpenRouter()
+			if err != nil {
+				return fmt.Errorf("Object %s is not available locally and the server can't be used: %v", p.Oid, err)
+			}
+		}
+
+		size, err := router.ObjectSize(p.Oid, p.Size)
+		if err != nil {
+			return err
+		}
+
+		if size != p.Size {
+			missing = append(missing, "  "+p.Oid)
+			continue
+		}
+
+		remoteObjects[p.Oid] = true
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("The following LFS objects are neither available locally nor on the server:\n%s", strings.Join(missing, "\n"))
+	}
+
+	fmt.Printf("Writing %d LFS objects (%s) to %q...\n", len(pointers), internal.FormatSize(total), file)
+
+	// Write a temporary file first so that an incomplete bundle is never mistaken for a complete one
+	output, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		output.Close()
+		os.Remove(output.Name())
+	}()
+
+	writer, err := internal.NewBundleWriter(output, pointers)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pointers {
+		var reader io.ReadCloser
+		if remoteObjects[p.Oid] {
+			reader, err = router.OpenObject(p.Oid, p.Size)
+			if err == nil && reader == nil {
+				err = fmt.Errorf("The object doesn't exist on the server anymore")
+			}
+		} else {
+			reader, err = os.Open(internal.LocalObjectPath(gitPath, p.Oid))
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to read object %s: %v", p.Oid, err)
+		}
+
+		err = writer.Add(reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writer.Close()
+	if err == nil {
+		err = output.Close()
+	}
+	if err == nil {
+		err = os.Rename(output.Name(), file)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write bundle %q: %v", file, err)
+	}
+
+	fmt.Printf("Successfully created %q! Import it using 'git-lfs-webdav bundle import %s'.\n", file, filepath.Base(file))
+
+	return nil
+}
+
+func bundleImport(args []string) error {
+	flags := flag.NewFlagSet("bundle import", flag.ContinueOnError)
+	local := flags.Bool("local", false, "Store the objects in the local LFS storage instead of uploading them to the server")
+	jobs := flags.Int("jobs", internal.Concurrency(), "Number of parallel uploads")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("Usage: git-lfs-webdav bundle import [--local] [--jobs <n>] <file>")
+	}
+
+	gitPath, err := internal.GitGetPath()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	bundle, err := internal.OpenBundle(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read bundle %q: %v", flags.Arg(0), err)
+	}
+
+	if *local {
+		count, err := importObjects(bundle, gitPath, nil)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Successfully imported %d of %d LFS objects into the local LFS storage!\n", count, len(bundle.Objects))
+		return nil
+	}
+
+	router, err := internal.OpenRouter()
+	if err != nil {
+		return err
+	}
+
+	// Authenticate once before the uploads run in parallel
+	err = router.Authenticate()
+	if err != nil {
+		return err
+	}
+
+	signingKey, err := pushSigningKey()
+	if err != nil {
+		return err
+	}
+
+	// The objects are verified into a staging area which is uploaded like 'push --all' afterwards
+	staging, err := ioutil.TempDir(filepath.Join(gitPath, "lfs", "tmp"), "bundle-")
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Join(gitPath, "lfs", "tmp"), 0755)
+		if err == nil {
+			staging, err = ioutil.TempDir(filepath.Join(gitPath, "lfs", "tmp"), "bundle-")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(staging)
+
+	present := make([]*internal.Pointer, 0)
+	missing := make([]*internal.Pointer, 0)
+	var total int64
+
+	_, err = importObjects(bundle, staging, func(o *internal.Pointer) (bool, error) {
+		size, err := router.ObjectSize(o.Oid, o.Size)
+		if err != nil {
+			return false, err
+		}
+
+		// Objects on the server are staged as well, since they are only signed once their content has been verified
+		if size == o.Size {
+			present = append(present, o)
+			return signingKey != nil, nil
+		} else if size >= 0 {
+			return false, fmt.Errorf("Object %s exists on the server with size %d instead of %d, run 'git-lfs-webdav repair'", o.Oid, size, o.Size)
+		}
+
+		missing = append(missing, o)
+		total += o.Size
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d LFS objects exist on the server, %d are missing (%s).\n", len(present), len(missing), internal.FormatSize(total))
+
+	uploaded, failed := pushObjects(router, staging, missing, *jobs, total)
+
+	if signed := append(present, uploaded...); signingKey != nil && len(signed) > 0 {
+		err = router.Primary.AppendManifest(signingKey, signed)
+		if err != nil {
+			return fmt.Errorf("Failed to append to the manifest: %v", err)
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "The following LFS objects could not be uploaded:\n%s\n", strings.Join(failed, "\n"))
+		return fmt.Errorf("Uploaded %d of %d missing LFS objects", len(uploaded), len(missing))
+	}
+
+	fmt.Printf("Successfully uploaded %d LFS objects!\n", len(uploaded))
+
+	return nil
+}
+
+// importObjects verifies the objects of the bundle and stores the ones accepted by the filter (all if it is nil)
+// in the LFS storage of the given git folder. Objects which exist there already are skipped.
+func importObjects(bundle *internal.BundleReader, gitPath string, filter func(o *internal.Pointer) (bool, error)) (int, error) {
+	count := 0
+
+	for {
+		o, reader, err := bundle.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return count, err
+		}
+
+		if info, err := os.Stat(internal.LocalObjectPath(gitPath, o.Oid)); err == nil && info.Size() == o.Size {
+			continue
+		}
+
+		if filter != nil {
+			accepted, err := filter(o)
+			if err != nil {
+				return count, err
+			}
+
+			if !accepted {
+				continue
+			}
+		}
+
+		err = internal.StoreLocalObject(gitPath, o.Oid, o.Size, reader)
+		if err != nil {
+			return count, fmt.Errorf("Object %s of the bundle is corrupt: %v", o.Oid, err)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
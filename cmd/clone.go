@@ -0,0 +1,95 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// Clone executes the clone command
+func Clone(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("Usage: git-lfs-webdav clone <repository> [directory]")
+	}
+
+	repo := args[0]
+
+	dir := cloneDir(repo)
+	if len(args) > 1 {
+		dir = args[1]
+	}
+
+	// The transfer agent is configured after cloning, so the path has to be determined before changing the folder
+	agentPath, err := agentExecutable()
+	if err != nil {
+		return err
+	}
+
+	// Clone without downloading the LFS files since Git LFS doesn't know about us yet
+	fmt.Printf("[1/3] Cloning %s into %q...\n", repo, dir)
+	err = internal.GitRun("", []string{"GIT_LFS_SKIP_SMUDGE=1"}, "clone", repo, dir)
+	if err != nil {
+		return err
+	}
+
+	err = os.Chdir(dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("[2/3] Configuring LFS WebDAV...")
+	err = configureAgent(agentPath)
+	if err != nil {
+		return err
+	}
+
+	lfsURL, err := internal.GetLFSURL()
+	if err != nil {
+		return fmt.Errorf("%v\nThe repository doesn't seem to use LFS WebDAV, run 'git-lfs-webdav init <url>' inside %q", err, dir)
+	}
+
+	// Download the LFS files of the checked out branch and replace the pointer files
+	fmt.Printf("[3/3] Downloading LFS files from %q...\n", lfsURL)
+	err = internal.GitRun("", nil, "lfs", "pull")
+	if err != nil {
+		return fmt.Errorf("%v\nThe repository has been cloned, run 'git lfs pull' inside %q to try again (e.g. after 'git-lfs-webdav login')", err, dir)
+	}
+
+	fmt.Printf("Successfully cloned into %q!\n", dir)
+
+	return nil
+}
+
+// cloneDir guesses the folder name like 'git clone' does (e.g. "repo" for "https://host/repo.git")
+func cloneDir(repo string) string {
+	name := strings.TrimRight(repo, "/\\")
+	name = strings.TrimSuffix(name, "/.git")
+	name = strings.TrimSuffix(name, ".git")
+
+	if i := strings.LastIndexAny(name, "/\\:"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return name
+}
@@ -0,0 +1,383 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// doctorCheck prints the result of a single check and counts the failures
+type doctorCheck struct {
+	failed       int
+	authRequired bool
+}
+
+func (d *doctorCheck) pass(name string, format string, a ...interface{}) {
+	fmt.Printf("[PASS] %s: %s\n", name, fmt.Sprintf(format, a...))
+}
+
+func (d *doctorCheck) fail(name string, fix string, format string, a ...interface{}) {
+	d.failed++
+
+	fmt.Printf("[FAIL] %s: %s\n", name, fmt.Sprintf(format, a...))
+	if len(fix) > 0 {
+		fmt.Printf("       Fix: %s\n", fix)
+	}
+}
+
+func (d *doctorCheck) skip(name string) {
+	fmt.Printf("[SKIP] %s: skipped because of the previous failure\n", name)
+}
+
+// Doctor executes the doctor command
+func Doctor(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Usage: git-lfs-webdav doctor")
+	}
+
+	d := &doctorCheck{}
+
+	d.checkAgent()
+
+	remote := d.checkURL()
+	if remote == nil {
+		d.skip("Connection")
+	}
+
+	if remote != nil && d.checkConnection(remote) {
+		if d.checkServer(remote) {
+			d.checkObject(remote)
+		} else {
+			d.skip("Test object")
+		}
+	} else {
+		d.skip("Authentication")
+		d.skip("WebDAV support")
+		d.skip("Test object")
+	}
+
+	d.checkRoutes(remote)
+
+	d.checkCredentials(remote)
+
+	if d.failed > 0 {
+		return fmt.Errorf("%d check(s) failed", d.failed)
+	}
+
+	fmt.Println("Everything looks fine!")
+
+	return nil
+}
+
+// checkAgent checks that Git LFS is configured to start this transfer agent
+func (d *doctorCheck) checkAgent() {
+	const fix = "run 'git-lfs-webdav init' in the repository"
+
+	// The agent can also be enabled for the LFS URL globally (see 'install')
+	agent, _ := internal.GitConfigGet("lfs.standalonetransferagent")
+	if lfsURL, err := internal.GetLFSURL(); err == nil {
+		agent = internal.GitConfigGetURLMatch("lfs.standalonetransferagent", lfsURL)
+	}
+
+	if agent != "webdav" {
+		d.fail("Standalone transfer agent", fix, "lfs.standalonetransferagent is %q instead of \"webdav\"", agent)
+	} else {
+		d.pass("Standalone transfer agent", "webdav")
+	}
+
+	agentArgs, _ := internal.GitConfigGet("lfs.customtransfer.webdav.args")
+	if fields := strings.Fields(agentArgs); len(fields) < 1 || fields[0] != "transfer" {
+		d.fail("Transfer agent arguments", fix, "lfs.customtransfer.webdav.args is %q instead of \"transfer\"", agentArgs)
+	} else {
+		d.pass("Transfer agent arguments", "%s", agentArgs)
+	}
+
+	agentPath, _ := internal.GitConfigGet("lfs.customtransfer.webdav.path")
+	if len(agentPath) < 1 {
+		d.fail("Transfer agent executable", fix, "lfs.customtransfer.webdav.path is not set")
+		return
+	}
+
+	// Git LFS starts the agent from the top level of the working tree, so relative paths are resolved there
+	resolved := agentPath
+	if topLevel, _ := internal.GitGetTopLevel(); len(topLevel) > 0 && strings.ContainsAny(agentPath, "/\\") && !filepath.IsAbs(agentPath) {
+		resolved = filepath.Join(topLevel, agentPath)
+	}
+
+	found, err := exec.LookPath(resolved)
+	if err != nil {
+		d.fail("Transfer agent executable", fix+" using the executable you want to use", "%q is not an executable: %v", agentPath, err)
+	} else {
+		d.pass("Transfer agent executable", "%s", found)
+	}
+}
+
+// checkURL resolves the effective LFS URL and reports where it is configured
+func (d *doctorCheck) checkURL() *internal.Remote {
+	lfsURL, err := internal.GetLFSURL()
+	if err != nil {
+		d.fail("LFS URL", "run 'git-lfs-webdav init <url>' and commit .lfsconfig", "%v", err)
+		return nil
+	}
+
+	origin, _ := internal.GitConfigOrigin("lfs.url")
+	if len(origin) < 1 {
+		origin = internal.RepoFileLocation(internal.LFSConfigFile)
+	}
+
+	parsed, err := internal.ParseLFSURL(lfsURL)
+	if err != nil {
+		d.fail("LFS URL", "fix lfs.url in "+origin, "%v", err)
+		return nil
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		d.fail("LFS URL", "fix lfs.url in "+origin, "%q is not a http(s) or webdav(s) URL", lfsURL)
+		return nil
+	}
+
+	if parsed.User != nil {
+		d.fail("LFS URL", "run 'git-lfs-webdav login' to store the credentials securely", "the URL from %s contains cleartext credentials", origin)
+	} else {
+		d.pass("LFS URL", "%s (from %s)", parsed, origin)
+	}
+
+	remote, err := internal.NewRemote(lfsURL)
+	if err != nil {
+		d.fail("Configuration", "fix the lfs.webdav.* settings", "%v", err)
+		return nil
+	}
+
+	return remote
+}
+
+// checkRoutes checks that the servers of the routing rules (lfs.webdav.route) and the shards (lfs.webdav.shard) can be used
+func (d *doctorCheck) checkRoutes(remote *internal.Remote) {
+	routes, err := internal.LoadRoutes()
+	if err != nil {
+		d.fail("Routes", "fix lfs.webdav.route", "%v", err)
+		return
+	}
+
+	shards := internal.LoadShards()
+	if remote == nil || len(routes)+len(shards) < 1 {
+		return
+	}
+
+	router, err := internal.NewRouter(remote, routes, shards)
+	if err != nil {
+		d.fail("Routes and shards", "fix lfs.webdav.route and lfs.webdav.shard", "%v", err)
+		return
+	}
+
+	for i, shard := range router.Shards[1:] {
+		name := fmt.Sprintf("Shard %d", i+2)
+
+		_, err := shard.Stat("")
+		if err != nil && !internal.IsNotFound(err) {
+			d.fail(name, "check the URL and the credentials of "+shard.URL.String(), "%s: %v", shard.URL, err)
+		} else {
+			d.pass(name, "%s", shard.URL)
+		}
+	}
+
+	for i, route := range routes {
+		name := fmt.Sprintf("Route %d", i+1)
+
+		_, err := router.RouteRemote(i).Stat("")
+		if err != nil && !internal.IsNotFound(err) {
+			d.fail(name, "check the URL and the credentials of "+route.URL, "%s: %v", route, err)
+		} else {
+			d.pass(name, "%s", route)
+		}
+	}
+}
+
+// checkConnection checks that the server can be reached and that its certificate is valid
+func (d *doctorCheck) checkConnection(remote *internal.Remote) bool {
+	host := remote.URL.Host
+	if len(remote.URL.Port()) < 1 {
+		if remote.URL.Scheme == "https" {
+			host = net.JoinHostPort(remote.URL.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(remote.URL.Hostname(), "80")
+		}
+	}
+
+	if len(os.Getenv("HTTPS_PROXY")+os.Getenv("https_proxy")+os.Getenv("HTTP_PROXY")+os.Getenv("http_proxy")) > 0 {
+		d.pass("Connection", "not checked directly because a proxy is configured")
+		return true
+	}
+
+	conn, err := net.DialTimeout("tcp", host, 10*time.Second)
+	if err != nil {
+		d.fail("Connection", "check the host name and port of the URL and your network", "failed to connect to %s: %v", host, err)
+		return false
+	}
+
+	defer conn.Close()
+
+	if remote.URL.Scheme != "https" {
+		d.pass("Connection", "connected to %s (unencrypted, consider using https)", host)
+		return true
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: remote.URL.Hostname()})
+	err = tlsConn.Handshake()
+	if err != nil {
+		d.fail("Connection", "check the certificate of the server and the system time", "TLS handshake with %s failed: %v", host, err)
+		return false
+	}
+
+	certs := tlsConn.ConnectionState().PeerCertificates
+	d.pass("Connection", "connected to %s using TLS (certificate valid until %s)", host, certs[0].NotAfter.Format("2006-01-02"))
+
+	return true
+}
+
+// checkServer checks that the credentials are accepted and that the server supports WebDAV
+func (d *doctorCheck) checkServer(remote *internal.Remote) bool {
+	_, err := remote.Stat("")
+	if internal.HasStatus(err, http.StatusUnauthorized) {
+		d.authRequired = true
+		d.fail("Authentication", "run 'git-lfs-webdav login' (or 'git-lfs-webdav logout' if the stored credentials are wrong)", "the server rejected the credentials")
+		return false
+	} else if internal.HasStatus(err, http.StatusForbidden) {
+		d.fail("Authentication", "ask the administrator for access to the folder", "access to the folder is forbidden")
+		return false
+	} else if internal.IsNotFound(err) {
+		d.fail("Authentication", "create the folder on the server or check the path of the URL", "the folder doesn't exist")
+		return false
+	} else if err != nil {
+		d.fail("Authentication", "", "PROPFIND failed: %v", err)
+		return false
+	}
+
+	if remote.HasCredentials() {
+		d.authRequired = true
+		d.pass("Authentication", "logged in")
+	} else {
+		d.pass("Authentication", "the server doesn't require credentials")
+	}
+
+	header, err := remote.Options("")
+	if err != nil {
+		d.fail("WebDAV support", "check the path of the URL", "OPTIONS failed: %v", err)
+		return false
+	}
+
+	if dav := header.Get("Dav"); !strings.Contains(dav, "1") {
+		d.fail("WebDAV support", "enable WebDAV on the server or check the path of the URL", "the server doesn't report WebDAV support (DAV: %q)", dav)
+		return false
+	}
+
+	d.pass("WebDAV support", "DAV: %s", header.Get("Dav"))
+
+	return true
+}
+
+// checkObject writes, reads and deletes a test object
+func (d *doctorCheck) checkObject(remote *internal.Remote) {
+	random := make([]byte, 16)
+	rand.Read(random)
+
+	content := []byte("git-lfs-webdav doctor " + hex.EncodeToString(random) + "\n")
+	p := path.Join(remote.Layout.Prefix, "git-lfs-webdav-doctor-"+hex.EncodeToString(random[:4])+".tmp")
+
+	err := remote.EnsureDir(remote.Layout.Prefix)
+	if err == nil {
+		err = remote.CreateStream(p, bytes.NewReader(content), int64(len(content)))
+	}
+	if err != nil {
+		d.fail("Test object", "check the write permissions of the folder", "failed to write %q: %v", p, err)
+		return
+	}
+
+	// Don't leave the test object behind if reading it fails
+	deleted := false
+	defer func() {
+		if !deleted {
+			remote.Delete(p)
+		}
+	}()
+
+	reader, err := remote.ReadStream(p)
+	if err != nil {
+		d.fail("Test object", "check the read permissions of the folder", "failed to read %q: %v", p, err)
+		return
+	}
+
+	read, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(read, content) {
+		d.fail("Test object", "check whether the server modifies uploaded files", "%q was read back with a different content", p)
+		return
+	}
+
+	deleted = true
+	err = remote.Delete(p)
+	if err != nil {
+		d.fail("Test object", "check the delete permissions of the folder and remove it manually", "failed to delete %q: %v", p, err)
+		return
+	}
+
+	d.pass("Test object", "written, read and deleted %q", p)
+}
+
+// checkCredentials checks where credentials come from
+func (d *doctorCheck) checkCredentials(remote *internal.Remote) {
+	if remote == nil {
+		d.skip("Credential helper")
+		return
+	}
+
+	if helper := internal.GitCredentialHelper(remote.URL.String()); len(helper) > 0 {
+		d.pass("Credential helper", "%s", helper)
+		return
+	}
+
+	creds, err := internal.LoadStoredCredentials(remote.URL.String())
+	if err != nil {
+		d.fail("Credential helper", "set "+internal.PassphraseEnv+" or run 'git-lfs-webdav logout' and 'git-lfs-webdav login'", "%v", err)
+	} else if creds != nil {
+		d.pass("Credential helper", "none, but 'git-lfs-webdav login' stored credentials")
+	} else if d.authRequired {
+		d.fail("Credential helper", "configure credential.helper or run 'git-lfs-webdav login'", "none, the credentials can't be stored")
+	} else {
+		d.pass("Credential helper", "none (not needed so far)")
+	}
+}
@@ -0,0 +1,182 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// Fetch executes the fetch command
+func Fetch(args []string) error {
+	flags := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	all := flags.Bool("all", false, "Fetch the objects of all commits of all refs instead of only the checked out files of the given refs")
+	recent := flags.Int("recent", 0, "Only use the given number of refs with the newest commits (the refs are patterns then)")
+	jobs := flags.Int("jobs", internal.Concurrency(), "Number of parallel downloads")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *all && (flags.NArg() > 0 || *recent > 0) {
+		return fmt.Errorf("Usage: git-lfs-webdav fetch [--jobs <n>] [--all | [--recent <n>] [refs...]]")
+	}
+
+	gitPath, err := internal.GitGetPath()
+	if err != nil {
+		return err
+	}
+
+	var pointers []*internal.Pointer
+	if *all {
+		fmt.Println("Collecting the LFS objects of all commits...")
+		pointers, err = internal.GitLFSPointers("--all")
+	} else {
+		refs := flags.Args()
+		if *recent > 0 {
+			refs, err = internal.GitRecentRefs(*recent, refs...)
+			if err != nil {
+				return err
+			}
+		} else if len(refs) < 1 {
+			refs = []string{"HEAD"}
+		}
+
+		if len(refs) < 1 {
+			return fmt.Errorf("No matching refs found")
+		}
+
+		// Like 'git lfs fetch <refs>' only the files of the commits the refs point to are fetched
+		fmt.Printf("Collecting the LFS objects of %s...\n", strings.Join(refs, ", "))
+		pointers, err = internal.GitLFSPointers(append([]string{"--no-walk"}, refs...)...)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Objects which exist locally with the right size are skipped
+	missing := make([]*internal.Pointer, 0)
+	var total int64
+	for _, p := range pointers {
+		if info, err := os.Stat(internal.LocalObjectPath(gitPath, p.Oid)); err == nil && info.Size() == p.Size {
+			continue
+		}
+
+		missing = append(missing, p)
+		total += p.Size
+	}
+
+	fmt.Printf("Found %d LFS objects, %d are missing locally (%s).\n", len(pointers), len(missing), internal.FormatSize(total))
+	if len(missing) < 1 {
+		return nil
+	}
+
+	// Start with the largest objects so that the parallel downloads finish at about the same time
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].Size > missing[j].Size
+	})
+
+	router, err := internal.OpenRouter()
+	if err != nil {
+		return err
+	}
+
+	// Authenticate once before the downloads run in parallel
+	err = router.Authenticate()
+	if err != nil {
+		return err
+	}
+
+	trustedKeys, err := internal.LoadTrustedKeys()
+	if err != nil {
+		return err
+	}
+
+	var manifest *internal.Manifest
+	if trustedKeys != nil {
+		manifest, err = router.Primary.LoadManifest(trustedKeys)
+		if err != nil {
+			return fmt.Errorf("Failed to read the manifest: %v", err)
+		}
+	}
+
+	mu := sync.Mutex{}
+	done := 0
+	var downloaded int64
+	failed := make([]string, 0)
+
+	internal.Parallel(len(missing), *jobs, func(i int) {
+		p := missing[i]
+
+		err := fetchObject(router, manifest, gitPath, p)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		done++
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("  %s: %v", p.Oid, err))
+		} else {
+			downloaded += p.Size
+		}
+
+		fmt.Fprintf(os.Stderr, "\rDownloading LFS objects: %d/%d, %s/%s", done, len(missing), internal.FormatSize(downloaded), internal.FormatSize(total))
+	})
+
+	fmt.Fprintln(os.Stderr, "")
+
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "The following LFS objects could not be downloaded:\n%s\n", strings.Join(failed, "\n"))
+		return fmt.Errorf("Failed to download %d of %d LFS objects", len(failed), len(missing))
+	}
+
+	fmt.Printf("Successfully downloaded %d LFS objects!\n", len(missing))
+
+	return nil
+}
+
+// fetchObject downloads a single object into the local Git LFS storage
+func fetchObject(router *internal.Router, manifest *internal.Manifest, gitPath string, p *internal.Pointer) error {
+	if manifest != nil {
+		if e, ok := manifest.Entries[p.Oid]; !ok || e.Size != p.Size {
+			return fmt.Errorf("not listed in a manifest signed by a trusted key")
+		}
+	}
+
+	reader, err := router.OpenObject(p.Oid, p.Size)
+	if err != nil {
+		return err
+	}
+
+	if reader == nil {
+		return fmt.Errorf("does not exist on the server")
+	}
+
+	defer reader.Close()
+
+	return internal.StoreLocalObject(gitPath, p.Oid, p.Size, reader)
+}
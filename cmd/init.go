@@ -20,29 +20,36 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/mpotthoff/git-lfs-webdav/internal"
 )
 
 // Init executes the init command
 func Init(args []string) error {
-	// Set the executable path of the transfer agent to our current call path
-	err := internal.GitConfigSet("lfs.customtransfer.webdav.path", os.Args[0])
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	noVerify := flags.Bool("no-verify", false, "Save the URL without checking the server")
+
+	err := flags.Parse(args)
 	if err != nil {
 		return err
 	}
 
-	// Set the executable argument to "transfer"
-	err = internal.GitConfigSet("lfs.customtransfer.webdav.args", "transfer")
+	args = flags.Args()
+
+	agentPath, err := agentExecutable()
 	if err != nil {
 		return err
 	}
 
-	// Force Git LFS to use us as the transfer agent without a proper LFS API
-	err = internal.GitConfigSet("lfs.standalonetransferagent", "webdav")
+	err = configureAgent(agentPath)
 	if err != nil {
 		return err
 	}
@@ -53,6 +60,19 @@ func Init(args []string) error {
 			return fmt.Errorf("Failed to parse url %q: %v", args[0], err)
 		}
 
+		// Check the server before saving the URL so that typos and servers without WebDAV are found now
+		// instead of on the first push
+		var caps *internal.Capabilities
+		if !*noVerify {
+			caps, err = verifyServer(u)
+			if internal.HasStatus(err, http.StatusUnauthorized) {
+				fmt.Println("The server requires credentials, so it can't be checked yet.")
+				fmt.Printf("Run 'git-lfs-webdav login' and afterwards 'git-lfs-webdav init %s' again.\n", args[0])
+			} else if err != nil {
+				return fmt.Errorf("%v\nUse --no-verify to save the URL anyway", err)
+			}
+		}
+
 		// Change http/https to webdav/webdavs so that Git will definitely fail
 		// on new clones. Otherwise it will try to contact the LFS URL with the
 		// LFS Bulk API and might report other errors.
@@ -66,16 +86,88 @@ func Init(args []string) error {
 
 		// Save the URL inside .lfsconfig so that it can be committed to the repository.
 		// Otherwise everyone that clones the repository would have to know the URL.
-		err = internal.GitConfigFileSet(".lfsconfig", "lfs.url", lfsURL)
+		err = internal.LFSConfigFileSet("lfs.url", lfsURL)
 		if err != nil {
 			return err
 		}
 
+		if caps != nil {
+			// Record the capabilities so that the transfer agent doesn't have to detect them every time
+			err = internal.LFSConfigFileSet("lfs.webdav.ranges", strconv.FormatBool(caps.Ranges))
+			if err != nil {
+				return err
+			}
+
+			err = internal.LFSConfigFileSet("lfs.webdav.deadProperties", strconv.FormatBool(caps.DeadProperties))
+			if err != nil {
+				return err
+			}
+		}
+
 		fmt.Printf("Successfully initialized LFS WebDAV with url %q!\n", lfsURL)
 	} else {
 		fmt.Println("Successfully initialized LFS WebDAV!")
-		fmt.Println("If you have just cloned the repository run 'git reset --hard master' to checkout the LFS files.")
+		fmt.Println("If you have just cloned the repository run 'git reset --hard HEAD' to checkout the LFS files.")
 	}
 
 	return nil
 }
+
+// agentExecutable returns the path of this executable as it should be configured for the transfer agent.
+// Git LFS doesn't necessarily start it in the current folder (e.g. in subfolders, worktrees or submodules),
+// so relative paths are made absolute. A plain name is looked up on PATH.
+func agentExecutable() (string, error) {
+	agentPath := os.Args[0]
+	if strings.ContainsRune(agentPath, filepath.Separator) || strings.ContainsRune(agentPath, '/') {
+		absPath, err := filepath.Abs(agentPath)
+		if err != nil {
+			return "", err
+		}
+
+		agentPath = filepath.ToSlash(absPath)
+	}
+
+	return agentPath, nil
+}
+
+// configureAgent configures Git LFS in the current repository to use the given executable as transfer agent
+func configureAgent(agentPath string) error {
+	err := internal.GitConfigSet("lfs.customtransfer.webdav.path", agentPath)
+	if err != nil {
+		return err
+	}
+
+	// Set the executable argument to "transfer"
+	err = internal.GitConfigSet("lfs.customtransfer.webdav.args", "transfer")
+	if err != nil {
+		return err
+	}
+
+	// Force Git LFS to use us as the transfer agent without a proper LFS API
+	return internal.GitConfigSet("lfs.standalonetransferagent", "webdav")
+}
+
+// verifyServer connects to the server like the transfer agent and probes its capabilities with a temporary folder
+func verifyServer(u *url.URL) (*internal.Capabilities, error) {
+	fmt.Printf("Checking %q...\n", u.Host)
+
+	remote, err := internal.NewRemote(u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	caps, err := remote.Probe()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("The server supports PROPFIND, MKCOL, PUT, GET, MOVE and DELETE.")
+	if !caps.Ranges {
+		fmt.Println("The server doesn't support Range requests, packed objects will be slow to download.")
+	}
+	if !caps.DeadProperties {
+		fmt.Println("The server doesn't store dead properties, uploaded objects can't be annotated with their metadata.")
+	}
+
+	return caps, nil
+}
@@ -0,0 +1,218 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// Git LFS reads lfs.standalonetransferagent for the LFS URL of a repository like a URL-scoped git setting
+// (lfs.<url>.standalonetransferagent). Since 'init' saves the URL with the webdav/webdavs scheme, a global
+// setting for these URLs enables the transfer agent in every matching clone without running 'init'.
+var globalAgentSetting = regexp.MustCompile(`^lfs\.(webdavs?://.*)\.standalonetransferagent$`)
+
+// matchURLs converts a host name (e.g. "dav.example.com" or "*.example.com") or a URL prefix into the URLs of
+// the URL-scoped settings
+func matchURLs(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "://") {
+		pattern = strings.TrimRight(pattern, "/")
+		return []string{"webdav://" + pattern, "webdavs://" + pattern}, nil
+	}
+
+	u, err := url.Parse(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse url %q: %v", pattern, err)
+	}
+
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "webdav"
+	case "https":
+		u.Scheme = "webdavs"
+	case "webdav", "webdavs":
+	default:
+		return nil, fmt.Errorf("Unsupported scheme %q in %q", u.Scheme, pattern)
+	}
+
+	u.User = nil
+
+	return []string{u.String()}, nil
+}
+
+// globalAgentURLs returns the URLs for which the transfer agent is installed globally
+func globalAgentURLs() []string {
+	// An error just means that there are no such settings
+	settings, _ := internal.GitConfigGlobalGetRegexp(`^lfs\.webdavs?://.*\.standalonetransferagent$`)
+
+	urls := make([]string, 0)
+	for _, setting := range settings {
+		if m := globalAgentSetting.FindStringSubmatch(setting[0]); m != nil && setting[1] == "webdav" {
+			urls = append(urls, m[1])
+		}
+	}
+
+	return urls
+}
+
+// Install executes the install command
+func Install(args []string) error {
+	flags := flag.NewFlagSet("install", flag.ContinueOnError)
+	global := flags.Bool("global", false, "Install into the global git config of the user")
+	matches := make(stringList, 0)
+	flags.Var(&matches, "match", "Host name or URL prefix of the LFS URLs to use the transfer agent for (repeatable)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if !*global || len(matches) < 1 || flags.NArg() > 0 {
+		return fmt.Errorf("Usage: git-lfs-webdav install --global --match <host-or-url> [--match ...]\nUse 'git-lfs-webdav init' to configure a single repository.")
+	}
+
+	urls := make([]string, 0)
+	for _, match := range matches {
+		matched, err := matchURLs(match)
+		if err != nil {
+			return err
+		}
+
+		urls = append(urls, matched...)
+	}
+
+	agentPath, err := agentExecutable()
+	if err != nil {
+		return err
+	}
+
+	err = internal.GitConfigGlobalSet("lfs.customtransfer.webdav.path", agentPath)
+	if err != nil {
+		return err
+	}
+
+	err = internal.GitConfigGlobalSet("lfs.customtransfer.webdav.args", "transfer")
+	if err != nil {
+		return err
+	}
+
+	for _, u := range urls {
+		err = internal.GitConfigGlobalSet("lfs."+u+".standalonetransferagent", "webdav")
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Using LFS WebDAV for %s\n", u)
+	}
+
+	fmt.Println("Successfully installed LFS WebDAV globally! Matching repositories don't need 'git-lfs-webdav init' anymore.")
+
+	return nil
+}
+
+// Uninstall executes the uninstall command
+func Uninstall(args []string) error {
+	flags := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	global := flags.Bool("global", false, "Uninstall from the global git config of the user")
+	matches := make(stringList, 0)
+	flags.Var(&matches, "match", "Only remove the given host name or URL prefix (repeatable)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if !*global || flags.NArg() > 0 {
+		return fmt.Errorf("Usage: git-lfs-webdav uninstall --global [--match <host-or-url> ...]")
+	}
+
+	installed := globalAgentURLs()
+
+	remove := installed
+	if len(matches) > 0 {
+		remove = make([]string, 0)
+		for _, match := range matches {
+			matched, err := matchURLs(match)
+			if err != nil {
+				return err
+			}
+
+			for _, u := range matched {
+				if containsString(installed, u) {
+					remove = append(remove, u)
+				}
+			}
+		}
+	}
+
+	for _, u := range remove {
+		err = internal.GitConfigGlobalUnset("lfs." + u + ".standalonetransferagent")
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Not using LFS WebDAV for %s anymore\n", u)
+	}
+
+	// The transfer agent itself is only removed once it isn't used by any URL anymore
+	if len(remove) == len(installed) {
+		for _, name := range []string{"lfs.customtransfer.webdav.path", "lfs.customtransfer.webdav.args"} {
+			if value, _ := internal.GitConfigGlobalGetRegexp("^" + regexp.QuoteMeta(name) + "$"); len(value) > 0 {
+				err = internal.GitConfigGlobalUnset(name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		fmt.Println("Successfully uninstalled LFS WebDAV globally.")
+	} else {
+		fmt.Printf("LFS WebDAV is still installed for %d other URLs.\n", len(installed)-len(remove))
+	}
+
+	return nil
+}
+
+// stringList is a flag which can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -21,6 +21,7 @@ package cmd
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"net/url"
 	"os"
@@ -32,21 +33,64 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// getLoginURL gets the URL the credentials are stored for (without any credentials)
+func getLoginURL() (*url.URL, error) {
+	// Always read the URL from .lfsconfig in case it changes in the repository.
+	rawLFSURL, err := internal.LFSConfigFileGet("lfs.url")
+	if err != nil {
+		return nil, err
+	}
+
+	lfsURL, err := internal.ParseLFSURL(rawLFSURL)
+	if err != nil {
+		return nil, err
+	}
+
+	lfsURL.User = nil
+
+	return lfsURL, nil
+}
+
+// removeLegacyLogin removes the URL with cleartext credentials from .git/config
+// which was saved by older versions of the login command
+func removeLegacyLogin() error {
+	localURL, err := internal.GitConfigGet("lfs.url")
+	if err != nil || len(localURL) < 1 {
+		return nil
+	}
+
+	parsed, err := url.Parse(localURL)
+	if err != nil || parsed.User == nil {
+		return nil
+	}
+
+	err = internal.GitConfigUnset("lfs.url")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Removed the cleartext credentials from .git/config.")
+
+	return nil
+}
+
 // Login executes the login command
 func Login(args []string) error {
-	reader := bufio.NewReader(os.Stdin)
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	usePassphrase := flags.Bool("passphrase", false, "Protect a new credential store with a passphrase instead of a key file")
 
-	// Always read the URL from .lfsconfig in case it changes in the repository.
-	rawLFSURL, err := internal.GitConfigFileGet(".lfsconfig", "lfs.url")
+	err := flags.Parse(args)
 	if err != nil {
 		return err
 	}
 
-	lfsURL, err := url.Parse(rawLFSURL)
+	lfsURL, err := getLoginURL()
 	if err != nil {
-		return fmt.Errorf("Failed to parse url %q: %v", rawLFSURL, err)
+		return err
 	}
 
+	reader := bufio.NewReader(os.Stdin)
+
 	fmt.Printf("Username for %q: ", lfsURL.Host)
 	username, err := reader.ReadString('\n')
 	if err != nil {
@@ -65,35 +109,33 @@ func Login(args []string) error {
 
 	password := strings.TrimSpace(string(passwordBytes))
 
-	if len(password) > 0 {
-		lfsURL.User = url.UserPassword(username, password)
-	} else if len(username) > 0 {
-		lfsURL.User = url.User(username)
-	} else {
-		lfsURL.User = nil
+	if len(username) < 1 && len(password) < 1 {
+		return fmt.Errorf("No credentials entered, use 'git-lfs-webdav logout' to remove stored credentials")
 	}
 
-	newLFSURL := lfsURL.String()
+	if helper := internal.GitCredentialHelper(lfsURL.String()); len(helper) > 0 {
+		// Hand the credentials to the configured credential helper so that they are stored securely
+		creds := make(internal.Creds)
+		creds["url"] = lfsURL.String()
+		creds["username"] = username
+		creds["password"] = password
 
-	if rawLFSURL != newLFSURL {
-		// Save the URL with the login credentials in .git/config
-		// because otherwise someone might commit it accidentally.
-		err := internal.GitConfigSet("lfs.url", newLFSURL)
+		err = internal.GitCredentialApprove(creds)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println("Successfully set login credentials!")
+		fmt.Printf("Successfully stored the login credentials in the credential helper %q!\n", helper)
 	} else {
-		// If the URL is exactly the same just remove it from .git/config
-		// so that the one in .lfsconfig is used instead.
-		err := internal.GitConfigUnset("lfs.url")
+		// Without a credential helper store them in the encrypted credential store of the user
+		err = internal.StoreCredentials(lfsURL.String(), username, password, *usePassphrase)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println("Successfully unset login credentials.")
+		storePath, _ := internal.CredentialStorePath()
+		fmt.Printf("Successfully stored the login credentials in %q!\n", storePath)
 	}
 
-	return nil
+	return removeLegacyLogin()
 }
@@ -0,0 +1,65 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// Logout executes the logout command
+func Logout(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Usage: git-lfs-webdav logout")
+	}
+
+	lfsURL, err := getLoginURL()
+	if err != nil {
+		return err
+	}
+
+	// Let the credential helper (if any) forget the credentials
+	creds := make(internal.Creds)
+	creds["url"] = lfsURL.String()
+
+	err = internal.GitCredentialReject(creds)
+	if err != nil {
+		return err
+	}
+
+	removed, err := internal.RemoveStoredCredentials(lfsURL.String())
+	if err != nil {
+		return err
+	}
+
+	if removed {
+		fmt.Println("Removed the login credentials from the credential store.")
+	}
+
+	err = removeLegacyLogin()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully logged out from %q.\n", lfsURL.Host)
+
+	return nil
+}
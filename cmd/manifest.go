@@ -0,0 +1,269 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// Manifest executes the manifest command
+func Manifest(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("Usage: git-lfs-webdav manifest <keygen|pubkey|sign|verify> [args...]")
+	}
+
+	switch args[0] {
+	case "keygen":
+		return manifestKeygen(args[1:])
+	case "pubkey":
+		return manifestPubkey(args[1:])
+	case "sign":
+		return manifestSign(args[1:])
+	case "verify":
+		return manifestVerify(args[1:])
+	}
+
+	return fmt.Errorf("Unknown manifest command %q", args[0])
+}
+
+func manifestKeygen(args []string) error {
+	flags := flag.NewFlagSet("manifest keygen", flag.ContinueOnError)
+	name := flags.String("name", "", "Name of the signer in the trusted keys (defaults to user.email)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	keyPath := flags.Arg(0)
+	if len(keyPath) < 1 {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
+
+		keyPath = filepath.Join(configDir, "git-lfs-webdav", "signing.key")
+	}
+
+	keyPath, err = filepath.Abs(keyPath)
+	if err != nil {
+		return err
+	}
+
+	key, err := internal.GenerateSigningKey(keyPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully created the signing key %q!\n\n", keyPath)
+	fmt.Printf("Use it for all repositories with:\n  git config --global lfs.webdav.signingKey %s\n\n", quoteShell(keyPath))
+	fmt.Printf("Add the following line to %s and commit it:\n  %s\n", internal.TrustedKeysFile, internal.FormatTrustedKey(key.Public().(ed25519.PublicKey), signerName(*name)))
+
+	return nil
+}
+
+func manifestPubkey(args []string) error {
+	flags := flag.NewFlagSet("manifest pubkey", flag.ContinueOnError)
+	name := flags.String("name", "", "Name of the signer in the trusted keys (defaults to user.email)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	key, err := requireSigningKey()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(internal.FormatTrustedKey(key.Public().(ed25519.PublicKey), signerName(*name)))
+
+	return nil
+}
+
+// manifestSign adds objects which are on the server but not in the manifest yet (e.g. uploaded before signing was set up)
+func manifestSign(args []string) error {
+	gitPath, err := internal.GitGetPath()
+	if err != nil {
+		return err
+	}
+
+	key, err := requireSigningKey()
+	if err != nil {
+		return err
+	}
+
+	trusted, err := internal.LoadTrustedKeys()
+	if err != nil {
+		return err
+	}
+
+	// The own key is enough to know what has been signed already
+	public := key.Public().(ed25519.PublicKey)
+	if internal.FindTrustedKey(trusted, public) == nil {
+		if trusted != nil {
+			fmt.Fprintf(os.Stderr, "Warning: The signing key is not listed in %q, so the signatures will be ignored.\n", internal.TrustedKeysFile)
+		}
+
+		trusted = append(trusted, &internal.TrustedKey{Name: "signing key", Key: public})
+	}
+
+	pointers, err := internal.GitLFSPointers(revsOrHead(args)...)
+	if err != nil {
+		return err
+	}
+
+	router, err := internal.OpenRouter()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := router.Primary.LoadManifest(trusted)
+	if err != nil {
+		return err
+	}
+
+	objects := make([]*internal.Pointer, 0)
+	skipped := make([]string, 0)
+
+	for _, p := range pointers {
+		if e, ok := manifest.Entries[p.Oid]; ok && e.Size == p.Size {
+			continue
+		}
+
+		size, err := router.ObjectSize(p.Oid, p.Size)
+		if err != nil {
+			return err
+		}
+
+		if size != p.Size {
+			skipped = append(skipped, fmt.Sprintf("  %s (not on the server)", p.Oid))
+			continue
+		}
+
+		// Only sign what has been verified locally
+		err = internal.VerifyLocalObject(gitPath, p.Oid, p.Size)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("  %s (no valid local copy: %v)", p.Oid, err))
+			continue
+		}
+
+		objects = append(objects, p)
+	}
+
+	err = router.Primary.AppendManifest(key, objects)
+	if err != nil {
+		return err
+	}
+
+	if len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "The following LFS objects have not been signed:\n%s\n", strings.Join(skipped, "\n"))
+	}
+
+	fmt.Printf("Successfully signed %d LFS objects!\n", len(objects))
+
+	return nil
+}
+
+func manifestVerify(args []string) error {
+	trusted, err := internal.LoadTrustedKeys()
+	if err != nil {
+		return err
+	}
+
+	if trusted == nil {
+		return fmt.Errorf("There are no trusted keys in %q", internal.TrustedKeysFile)
+	}
+
+	pointers, err := internal.GitLFSPointers(revsOrHead(args)...)
+	if err != nil {
+		return err
+	}
+
+	remote, err := internal.OpenRemote()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := remote.LoadManifest(trusted)
+	if err != nil {
+		return err
+	}
+
+	if len(manifest.Untrusted) > 0 {
+		fmt.Fprintf(os.Stderr, "Ignored the following manifest segments:\n  %s\n", strings.Join(manifest.Untrusted, "\n  "))
+	}
+
+	problems := make([]string, 0)
+	for _, p := range pointers {
+		e, ok := manifest.Entries[p.Oid]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("  %s (not signed)", p.Oid))
+		} else if e.Size != p.Size {
+			problems = append(problems, fmt.Sprintf("  %s (signed by %s with size %d instead of %d)", p.Oid, e.Signer, e.Size, p.Size))
+		}
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "The following LFS objects are not signed by a trusted key:\n%s\n", strings.Join(problems, "\n"))
+		return fmt.Errorf("%d of %d LFS objects are not signed by a trusted key", len(problems), len(pointers))
+	}
+
+	fmt.Printf("All %d LFS objects are signed by a trusted key.\n", len(pointers))
+
+	return nil
+}
+
+func requireSigningKey() (ed25519.PrivateKey, error) {
+	key, err := internal.LoadSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("No signing key configured. Create one using 'git-lfs-webdav manifest keygen'")
+	}
+
+	return key, nil
+}
+
+func signerName(name string) string {
+	if len(name) > 0 {
+		return name
+	}
+
+	email, _ := internal.GitConfigGet("user.email")
+	return email
+}
+
+func revsOrHead(args []string) []string {
+	if len(args) < 1 {
+		return []string{"HEAD"}
+	}
+
+	return args
+}
@@ -0,0 +1,326 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// Push executes the push command
+func Push(args []string) error {
+	flags := flag.NewFlagSet("push", flag.ContinueOnError)
+	all := flags.Bool("all", false, "Upload every object of the local LFS storage which is missing on the server")
+	jobs := flags.Int("jobs", internal.Concurrency(), "Number of parallel uploads")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if !*all || flags.NArg() > 0 {
+		return fmt.Errorf("Usage: git-lfs-webdav push [--jobs <n>] --all\nUse 'git push' to push the objects of single refs.")
+	}
+
+	gitPath, err := internal.GitGetPath()
+	if err != nil {
+		return err
+	}
+
+	objects, err := internal.LocalObjects(gitPath)
+	if err != nil {
+		return err
+	}
+
+	router, err := internal.OpenRouter()
+	if err != nil {
+		return err
+	}
+
+	// Authenticate once before the uploads run in parallel
+	err = router.Authenticate()
+	if err != nil {
+		return err
+	}
+
+	signingKey, err := pushSigningKey()
+	if err != nil {
+		return err
+	}
+
+	// The folder listings and pack indexes are cached, so this only costs a request per folder
+	fmt.Printf("Checking %d local LFS objects...\n", len(objects))
+
+	missing := make([]*internal.Pointer, 0)
+	present := make([]*internal.Pointer, 0)
+	corrupt := make([]string, 0)
+	var total int64
+
+	for _, o := range objects {
+		size, err := router.ObjectSize(o.Oid, o.Size)
+		if err != nil {
+			return err
+		}
+
+		if size == o.Size {
+			present = append(present, o)
+		} else if size >= 0 {
+			corrupt = append(corrupt, fmt.Sprintf("  %s: exists with size %d instead of %d", o.Oid, size, o.Size))
+		} else {
+			missing = append(missing, o)
+			total += o.Size
+		}
+	}
+
+	fmt.Printf("%d LFS objects exist on the server, %d are missing (%s).\n", len(present), len(missing), internal.FormatSize(total))
+
+	failed := make([]string, 0)
+	uploaded := make([]*internal.Pointer, 0)
+
+	if len(missing) > 0 {
+		uploaded, failed = pushObjects(router, gitPath, missing, *jobs, total)
+	}
+
+	// Like the transfer agent every object which is on the server now is added to the manifest. The uploaded
+	// objects have been verified already, the others are only signed if their local copy matches the oid.
+	if signingKey != nil {
+		signed := append([]*internal.Pointer{}, uploaded...)
+		unsigned := make([]string, 0)
+		for _, o := range present {
+			err = internal.VerifyLocalObject(gitPath, o.Oid, o.Size)
+			if err != nil {
+				unsigned = append(unsigned, fmt.Sprintf("  %s (no valid local copy: %v)", o.Oid, err))
+				continue
+			}
+
+			signed = append(signed, o)
+		}
+
+		err = router.Primary.AppendManifest(signingKey, signed)
+		if err != nil {
+			return fmt.Errorf("Failed to append to the manifest: %v", err)
+		}
+
+		if len(unsigned) > 0 {
+			fmt.Fprintf(os.Stderr, "The following LFS objects have not been signed:\n%s\n", strings.Join(unsigned, "\n"))
+		}
+	}
+
+	if len(corrupt) > 0 {
+		fmt.Fprintf(os.Stderr, "The following LFS objects are probably corrupt on the server, run 'git-lfs-webdav repair' to replace them:\n%s\n", strings.Join(corrupt, "\n"))
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "The following LFS objects could not be uploaded:\n%s\n", strings.Join(failed, "\n"))
+	}
+
+	if len(failed) > 0 || len(corrupt) > 0 {
+		return fmt.Errorf("Uploaded %d of %d missing LFS objects, %d could not be uploaded and %d are corrupt on the server",
+			len(uploaded), len(missing), len(failed), len(corrupt))
+	}
+
+	fmt.Printf("Successfully uploaded %d LFS objects!\n", len(uploaded))
+
+	return nil
+}
+
+// pushSigningKey loads the signing key and checks it like the transfer agent (nil if the manifest isn't used)
+func pushSigningKey() (ed25519.PrivateKey, error) {
+	trustedKeys, err := internal.LoadTrustedKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, err := internal.LoadSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects pushed without a trusted signature couldn't be downloaded by anyone
+	if trustedKeys != nil && (signingKey == nil || internal.FindTrustedKey(trustedKeys, signingKey.Public().(ed25519.PublicKey)) == nil) {
+		return nil, fmt.Errorf("Uploads have to be signed with a key listed in %q. "+
+			"Create one using 'git-lfs-webdav manifest keygen' and configure it as lfs.webdav.signingKey", internal.TrustedKeysFile)
+	}
+
+	return signingKey, nil
+}
+
+// pushObjects uploads the objects to the remotes chosen by the routing rules (small ones in packs if enabled)
+// and returns the uploaded ones and the failures
+func pushObjects(router *internal.Router, gitPath string, objects []*internal.Pointer, jobs int, total int64) ([]*internal.Pointer, []string) {
+	mu := sync.Mutex{}
+	uploaded := make([]*internal.Pointer, 0)
+	failed := make([]string, 0)
+	done := 0
+	var bytesDone int64
+
+	report := func(batch []*internal.Pointer, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		done += len(batch)
+		for _, o := range batch {
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("  %s: %v", o.Oid, err))
+			} else {
+				uploaded = append(uploaded, o)
+				bytesDone += o.Size
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "\rUploading LFS objects: %d/%d, %s/%s", done, len(objects), internal.FormatSize(bytesDone), internal.FormatSize(total))
+	}
+
+	loose := make([]*internal.Pointer, 0)
+	// Every server keeps its own packs (like in repack), so small objects are packed on the server chosen by the rules
+	small := make(map[*internal.Remote][]*internal.Pointer)
+	for _, o := range objects {
+		remote := router.Target(o.Oid, o.Size)
+		if remote.PackThreshold > 0 && o.Size < remote.PackThreshold {
+			small[remote] = append(small[remote], o)
+		} else {
+			loose = append(loose, o)
+		}
+	}
+
+	// Start with the largest objects so that the parallel uploads finish at about the same time
+	sort.Slice(loose, func(i, j int) bool {
+		return loose[i].Size > loose[j].Size
+	})
+
+	internal.Parallel(len(loose), jobs, func(i int) {
+		report(loose[i:i+1], pushObject(router.Target(loose[i].Oid, loose[i].Size), gitPath, loose[i]))
+	})
+
+	for _, remote := range router.Remotes() {
+		if len(small[remote]) > 0 {
+			pushPacked(remote, gitPath, small[remote], report)
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "")
+
+	return uploaded, failed
+}
+
+// pushObject verifies a local object and uploads it as a loose object
+func pushObject(remote *internal.Remote, gitPath string, o *internal.Pointer) error {
+	// Never upload a corrupt object, it couldn't be replaced by a correct one easily
+	err := internal.VerifyLocalObject(gitPath, o.Oid, o.Size)
+	if err != nil {
+		return fmt.Errorf("local copy is invalid: %v", err)
+	}
+
+	err = remote.EnsureDir(remote.Layout.ObjectDir(o.Oid))
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(internal.LocalObjectPath(gitPath, o.Oid))
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	err = remote.CreateObject(o.Oid, file, o.Size)
+	if internal.HasStatus(err, http.StatusPreconditionFailed) {
+		// Someone else has uploaded it in the meantime
+		return nil
+	}
+
+	return err
+}
+
+// pushPacked combines the small objects into packs of up to lfs.webdav.packSize
+func pushPacked(remote *internal.Remote, gitPath string, objects []*internal.Pointer, report func([]*internal.Pointer, error)) {
+	var writer *internal.PackWriter
+	batch := make([]*internal.Pointer, 0)
+
+	flush := func() {
+		if writer == nil {
+			return
+		}
+
+		if len(batch) < 1 {
+			writer.Remove()
+			writer = nil
+			return
+		}
+
+		_, err := remote.UploadPack(writer)
+		writer.Remove()
+		writer = nil
+
+		report(batch, err)
+		batch = make([]*internal.Pointer, 0)
+	}
+
+	for _, o := range objects {
+		if writer == nil {
+			var err error
+			writer, err = internal.NewPackWriter(filepath.Join(gitPath, "lfs", "tmp"))
+			if err != nil {
+				report([]*internal.Pointer{o}, err)
+				continue
+			}
+		}
+
+		err := addToPack(writer, gitPath, o)
+		if err != nil {
+			report([]*internal.Pointer{o}, err)
+			continue
+		}
+
+		batch = append(batch, o)
+
+		if writer.Size() >= remote.PackSize {
+			flush()
+		}
+	}
+
+	flush()
+}
+
+// addToPack verifies a local object and adds it to the pack
+func addToPack(writer *internal.PackWriter, gitPath string, o *internal.Pointer) error {
+	err := internal.VerifyLocalObject(gitPath, o.Oid, o.Size)
+	if err != nil {
+		return fmt.Errorf("local copy is invalid: %v", err)
+	}
+
+	file, err := os.Open(internal.LocalObjectPath(gitPath, o.Oid))
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return writer.Add(o.Oid, o.Size, file)
+}
@@ -0,0 +1,267 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// rebalanceMove is an object which is stored on a different remote than the one the router chooses for it
+type rebalanceMove struct {
+	oid  string
+	size int64
+	from *internal.Remote
+	to   *internal.Remote
+
+	// Packed objects are only copied, the packs are deleted after the server has been drained
+	packed bool
+}
+
+// Rebalance executes the rebalance command
+func Rebalance(args []string) error {
+	flags := flag.NewFlagSet("rebalance", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "Only print which objects would be moved")
+	jobs := flags.Int("jobs", internal.Concurrency(), "Number of parallel moves")
+	drain := make(stringList, 0)
+	flags.Var(&drain, "drain", "URL of a removed shard or route whose objects are moved away (repeatable)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("Usage: git-lfs-webdav rebalance [--dry-run] [--jobs <n>] [--drain <url>...]")
+	}
+
+	router, err := internal.OpenRouter()
+	if err != nil {
+		return err
+	}
+
+	// Authenticate once before the moves run in parallel
+	err = router.Authenticate()
+	if err != nil {
+		return err
+	}
+
+	remotes := router.Remotes()
+	drained := make([]*internal.Remote, 0)
+
+	// Servers which are not configured anymore are only emptied
+	for _, u := range drain {
+		r, err := internal.NewRemote(u)
+		if err != nil {
+			return err
+		}
+
+		for _, other := range remotes {
+			if other.URL.String() == r.URL.String() {
+				return fmt.Errorf("%s is still configured as a shard or route and can't be drained", r.URL)
+			}
+		}
+
+		_, err = r.Stat("")
+		if err != nil && !internal.HasStatus(err, http.StatusNotFound) {
+			return fmt.Errorf("%s: %v", r.URL, err)
+		}
+
+		remotes = append(remotes, r)
+		drained = append(drained, r)
+	}
+
+	fmt.Printf("Checking the objects on %d servers...\n", len(remotes))
+
+	// Collect the objects first so that the listings aren't affected by the moves.
+	// Packed objects stay in the packs of their server, which downloads search like every location.
+	moves := make([]*rebalanceMove, 0)
+	counts := make(map[*internal.Remote]int)
+	var total int64
+
+	for _, r := range remotes {
+		err = r.WalkObjects(r.Layout, func(oid string, info *internal.RemoteFile) error {
+			counts[r]++
+
+			if to := router.Target(oid, info.Size); to != r {
+				moves = append(moves, &rebalanceMove{oid: oid, size: info.Size, from: r, to: to})
+				total += info.Size
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Drained servers are emptied completely, including their packs
+	packs := make(map[*internal.Remote]map[string][]*internal.PackEntry)
+	for _, r := range drained {
+		packs[r], err = r.PackEntries()
+		if err != nil {
+			return err
+		}
+
+		moving := make(map[string]bool)
+		for _, m := range moves {
+			if m.from == r {
+				moving[m.oid] = true
+			}
+		}
+
+		for _, entries := range packs[r] {
+			for _, e := range entries {
+				if !moving[e.Oid] {
+					moves = append(moves, &rebalanceMove{oid: e.Oid, size: e.Size, from: r, to: router.Target(e.Oid, e.Size), packed: true})
+					moving[e.Oid] = true
+					counts[r]++
+					total += e.Size
+				}
+			}
+		}
+	}
+
+	for _, r := range remotes {
+		fmt.Printf("  %-50s %d objects\n", r.URL, counts[r])
+	}
+
+	fmt.Printf("%d objects (%s) have to be moved.\n", len(moves), internal.FormatSize(total))
+
+	if *dryRun {
+		for _, m := range moves {
+			fmt.Printf("Would move %s (%s) from %s to %s\n", m.oid, internal.FormatSize(m.size), m.from.URL, m.to.URL)
+		}
+
+		return nil
+	}
+
+	if len(moves) < 1 {
+		return nil
+	}
+
+	mu := sync.Mutex{}
+	done := 0
+	var bytesDone int64
+	failed := make([]string, 0)
+
+	internal.Parallel(len(moves), *jobs, func(i int) {
+		m := moves[i]
+
+		err := moveObject(m)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		done++
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("  %s: %v", m.oid, err))
+		} else {
+			bytesDone += m.size
+		}
+
+		fmt.Fprintf(os.Stderr, "\rMoving objects: %d/%d, %s/%s", done, len(moves), internal.FormatSize(bytesDone), internal.FormatSize(total))
+	})
+
+	fmt.Fprintln(os.Stderr, "")
+
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "The following objects could not be moved (they are still stored at their old location):\n%s\n", strings.Join(failed, "\n"))
+		return fmt.Errorf("Failed to move %d of %d objects", len(failed), len(moves))
+	}
+
+	for r, entries := range packs {
+		for name := range entries {
+			err = r.DeletePack(name)
+			if err != nil {
+				return fmt.Errorf("Failed to delete pack %q on %s: %v", name, r.URL, err)
+			}
+		}
+	}
+
+	fmt.Printf("Successfully moved %d objects!\n", len(moves))
+
+	return nil
+}
+
+// moveObject copies an object to its new remote and deletes the old copy once the new one has been verified.
+// The remotes can be different servers, so WebDAV MOVE can't be used.
+func moveObject(m *rebalanceMove) error {
+	newPath := m.to.Layout.ObjectPath(m.oid)
+
+	size, err := m.to.ObjectSize(m.oid)
+	if err != nil {
+		return err
+	}
+
+	if size >= 0 && size != m.size {
+		return fmt.Errorf("exists on %s with size %d instead of %d", m.to.URL, size, m.size)
+	}
+
+	// Otherwise it has already been copied (e.g. by an interrupted rebalance) and only the old copy is left
+	if size < 0 {
+		reader, err := m.from.OpenObject(m.oid, m.size)
+		if err != nil {
+			return err
+		}
+
+		if reader == nil {
+			return fmt.Errorf("does not exist on %s anymore", m.from.URL)
+		}
+
+		defer reader.Close()
+
+		err = m.to.EnsureDir(m.to.Layout.ObjectDir(m.oid))
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.New()
+		err = m.to.CreateObject(m.oid, io.TeeReader(reader, hash), m.size)
+		if internal.HasStatus(err, http.StatusPreconditionFailed) {
+			// Someone else has uploaded it in the meantime, which is fine as long as it is complete
+			info, err2 := m.to.Stat(newPath)
+			if err2 != nil || info.Size != m.size {
+				return fmt.Errorf("%q has been changed by someone else while copying it", newPath)
+			}
+		} else if err != nil {
+			return err
+		} else if hex.EncodeToString(hash.Sum(nil)) != m.oid {
+			// Never spread a corrupt object, the old copy is kept for 'repair'
+			m.to.Delete(newPath)
+			return fmt.Errorf("content on %s does not match its oid, run 'git-lfs-webdav repair'", m.from.URL)
+		}
+	}
+
+	if m.packed {
+		return nil
+	}
+
+	return m.from.Delete(m.from.Layout.ObjectPath(m.oid))
+}
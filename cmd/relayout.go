@@ -0,0 +1,168 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// relayoutMove is a file or folder which has been moved to the new layout
+type relayoutMove struct {
+	remote  *internal.Remote
+	oldPath string
+	newPath string
+}
+
+// Relayout executes the relayout command
+func Relayout(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("Usage: git-lfs-webdav relayout <layout> [prefix]")
+	}
+
+	// The git config takes precedence over .lfsconfig, so saving the new layout there would have no effect
+	for _, name := range []string{"lfs.webdav.layout", "lfs.webdav.prefix"} {
+		if value, _ := internal.GitConfigGet(name); len(value) > 0 {
+			return fmt.Errorf("%s is set to %q in the git config, which overrides .lfsconfig. Remove it using 'git config --unset %s' first", name, value, name)
+		}
+	}
+
+	router, err := internal.OpenRouter()
+	if err != nil {
+		return err
+	}
+
+	// The layout applies to every server, so the objects are moved on all of them
+	remote := router.Primary
+
+	prefix := remote.Layout.Prefix
+	if len(args) > 1 {
+		prefix = args[1]
+	}
+
+	newLayout, err := internal.ParseLayout(args[0], prefix)
+	if err != nil {
+		return err
+	}
+
+	// The new layout has to be saved afterwards, which isn't possible without a working tree
+	_, err = internal.RepoFilePath(internal.LFSConfigFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Moving objects from layout %s to layout %s...\n", remote.Layout, newLayout)
+
+	pending := make([]*relayoutMove, 0)
+
+	for _, r := range router.Remotes() {
+		// Collect the objects first so that the listing isn't affected by the moves
+		err = r.WalkObjects(r.Layout, func(oid string, info *internal.RemoteFile) error {
+			pending = append(pending, &relayoutMove{r, r.Layout.ObjectPath(oid), newLayout.ObjectPath(oid)})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// The packs and the manifest are stored below the prefix as well
+		if newLayout.Prefix != r.Layout.Prefix {
+			for _, dir := range []string{r.PackDir(), r.ManifestDir()} {
+				_, err = r.Stat(dir)
+				if internal.HasStatus(err, http.StatusNotFound) {
+					continue
+				} else if err != nil {
+					return err
+				}
+
+				pending = append(pending, &relayoutMove{r, dir, path.Join(newLayout.Prefix, path.Base(dir))})
+			}
+		}
+	}
+
+	moved := make([]*relayoutMove, 0, len(pending))
+
+	for _, m := range pending {
+		if m.oldPath == m.newPath {
+			continue
+		}
+
+		// Use WebDAV MOVE so that the objects never have to leave the server
+		err = m.remote.Move(m.oldPath, m.newPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to move %q to %q on %s: %v\n", m.oldPath, m.newPath, m.remote.URL, err)
+			break
+		}
+
+		moved = append(moved, m)
+	}
+
+	if err != nil {
+		return rollbackRelayout(moved)
+	}
+
+	fmt.Printf("Moved %d of %d files.\n", len(moved), len(pending))
+
+	// Save the new layout inside .lfsconfig so that everyone uses it after committing it
+	err = internal.LFSConfigFileSet("lfs.webdav.layout", newLayout.Spec)
+	if err != nil {
+		return err
+	}
+
+	if len(newLayout.Prefix) > 0 {
+		err = internal.LFSConfigFileSet("lfs.webdav.prefix", newLayout.Prefix)
+	} else if len(remote.Layout.Prefix) > 0 {
+		err = internal.LFSConfigFileUnset("lfs.webdav.prefix")
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully changed the layout to %s! Commit .lfsconfig to share it.\n", newLayout)
+
+	return nil
+}
+
+// rollbackRelayout moves the files back to the old layout, which is still the one in .lfsconfig
+func rollbackRelayout(moved []*relayoutMove) error {
+	fmt.Printf("Moving %d files back to the old layout...\n", len(moved))
+
+	failed := make([]string, 0)
+	for i := len(moved) - 1; i >= 0; i-- {
+		m := moved[i]
+
+		err := m.remote.Move(m.newPath, m.oldPath)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("  %s: %q to %q (%v)", m.remote.URL, m.newPath, m.oldPath, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "The following files could not be moved back, move them manually:\n%s\n", strings.Join(failed, "\n"))
+		return fmt.Errorf("Failed to change the layout and to restore %d files", len(failed))
+	}
+
+	return fmt.Errorf("Failed to change the layout, all files have been moved back")
+}
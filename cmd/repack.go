@@ -0,0 +1,208 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// Repack executes the repack command
+func Repack(args []string) error {
+	gitPath, err := internal.GitGetPath()
+	if err != nil {
+		return err
+	}
+
+	router, err := internal.OpenRouter()
+	if err != nil {
+		return err
+	}
+
+	// Everything reachable from any ref of this repository is kept
+	pointers, err := internal.GitLFSPointers("--all")
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[string]bool)
+	for _, p := range pointers {
+		referenced[p.Oid] = true
+	}
+
+	// Every server keeps its own packs, so the objects never move to another server
+	remotes := router.Remotes()
+	for _, remote := range remotes {
+		if len(remotes) > 1 {
+			fmt.Printf("Repacking %s\n", remote.URL)
+		}
+
+		err = repackRemote(remote, filepath.Join(gitPath, "lfs", "tmp"), referenced)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// repackRemote combines the packs and small loose objects of a server into new packs
+func repackRemote(remote *internal.Remote, tmpDir string, referenced map[string]bool) error {
+	packs, err := remote.PackEntries()
+	if err != nil {
+		return err
+	}
+
+	type object struct {
+		oid   string
+		size  int64
+		entry *internal.PackEntry
+	}
+
+	objects := make([]*object, 0)
+	kept := make(map[string]bool)
+	dropped := 0
+
+	// Small loose objects are moved into the packs as well
+	loose := make([]*object, 0)
+	if remote.PackThreshold > 0 {
+		err = remote.WalkObjects(remote.Layout, func(oid string, info *internal.RemoteFile) error {
+			if info.Size < remote.PackThreshold && referenced[oid] {
+				loose = append(loose, &object{oid, info.Size, nil})
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Loose objects go first because they take precedence over packed copies (e.g. after a repair)
+	for _, o := range loose {
+		objects = append(objects, o)
+		kept[o.oid] = true
+	}
+
+	for _, entries := range packs {
+		for _, e := range entries {
+			if !referenced[e.Oid] {
+				dropped++
+			} else if !kept[e.Oid] {
+				objects = append(objects, &object{e.Oid, e.Size, e})
+				kept[e.Oid] = true
+			}
+		}
+	}
+
+	if dropped == 0 && len(loose) == 0 && len(packs) <= 1 {
+		fmt.Println("Nothing to repack.")
+		return nil
+	}
+
+	fmt.Printf("Repacking %d objects from %d packs and %d loose objects (dropping %d unreferenced entries)...\n", len(objects), len(packs), len(loose), dropped)
+
+	var writer *internal.PackWriter
+	created := make(map[string]bool)
+
+	upload := func() error {
+		if writer == nil || writer.Size() == 0 {
+			return nil
+		}
+
+		name, err := remote.UploadPack(writer)
+		writer.Remove()
+		writer = nil
+
+		if err != nil {
+			return fmt.Errorf("Failed to upload pack: %v", err)
+		}
+
+		created[name] = true
+		return nil
+	}
+
+	for _, o := range objects {
+		if writer == nil {
+			writer, err = internal.NewPackWriter(tmpDir)
+			if err != nil {
+				return err
+			}
+		}
+
+		var reader io.ReadCloser
+		if o.entry != nil {
+			reader, err = remote.ReadPacked(o.entry)
+		} else {
+			reader, err = remote.ReadStream(remote.Layout.ObjectPath(o.oid))
+		}
+		if err != nil {
+			writer.Remove()
+			return fmt.Errorf("Failed to read object %s: %v", o.oid, err)
+		}
+
+		// This also verifies the hash of every object
+		err = writer.Add(o.oid, o.size, reader)
+		reader.Close()
+		if err != nil {
+			writer.Remove()
+			return err
+		}
+
+		if writer.Size() >= remote.PackSize {
+			err = upload()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = upload()
+	if err != nil {
+		return err
+	}
+
+	// Only remove the old data after all new packs have been uploaded
+	for name := range packs {
+		// Pack names are derived from their contents, so a new pack might replace an old one
+		if created[name] {
+			continue
+		}
+
+		err = remote.DeletePack(name)
+		if err != nil {
+			return fmt.Errorf("Failed to delete old pack %q: %v", name, err)
+		}
+	}
+
+	for _, o := range loose {
+		err = remote.Delete(remote.Layout.ObjectPath(o.oid))
+		if err != nil {
+			return fmt.Errorf("Failed to delete packed loose object %s: %v", o.oid, err)
+		}
+	}
+
+	fmt.Printf("Successfully repacked %d objects into %d packs!\n", len(objects), len(created))
+
+	return nil
+}
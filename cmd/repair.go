@@ -0,0 +1,251 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// Repair executes the repair command
+func Repair(args []string) error {
+	flags := flag.NewFlagSet("repair", flag.ContinueOnError)
+	quick := flags.Bool("quick", false, "Only check that the objects exist with the right size instead of downloading them")
+	dryRun := flags.Bool("dry-run", false, "Only report the broken objects")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	revs := flags.Args()
+	if len(revs) < 1 {
+		revs = []string{"HEAD"}
+	}
+
+	gitPath, err := internal.GitGetPath()
+	if err != nil {
+		return err
+	}
+
+	pointers, err := internal.GitLFSPointers(revs...)
+	if err != nil {
+		return err
+	}
+
+	router, err := internal.OpenRouter()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Checking %d LFS objects...\n", len(pointers))
+
+	broken := 0
+	repaired := 0
+	unfixable := make([]string, 0)
+
+	for _, p := range pointers {
+		remote, err := findObject(router, p)
+		if err != nil {
+			return err
+		}
+
+		problem, err := checkRemoteObject(remote, p, *quick)
+		if err != nil {
+			return err
+		}
+
+		if len(problem) < 1 {
+			continue
+		}
+
+		fmt.Printf("  %s: %s\n", p.Oid, problem)
+		broken++
+
+		if *dryRun {
+			continue
+		}
+
+		// Never replace a broken object with another broken one
+		err = internal.VerifyLocalObject(gitPath, p.Oid, p.Size)
+		if os.IsNotExist(err) {
+			unfixable = append(unfixable, fmt.Sprintf("  %s (%s, not available locally)", p.Oid, problem))
+			continue
+		} else if err != nil {
+			unfixable = append(unfixable, fmt.Sprintf("  %s (%s, no valid local copy: %v)", p.Oid, problem, err))
+			continue
+		}
+
+		err = reuploadObject(remote, gitPath, p)
+		if err != nil {
+			unfixable = append(unfixable, fmt.Sprintf("  %s (%s, upload failed: %v)", p.Oid, problem, err))
+			continue
+		}
+
+		repaired++
+	}
+
+	if broken == 0 {
+		fmt.Println("All LFS objects are intact.")
+		return nil
+	}
+
+	if *dryRun {
+		return nil
+	}
+
+	if len(unfixable) > 0 {
+		fmt.Fprintf(os.Stderr, "The following LFS objects could not be repaired, they have to be pushed from a clone which has them:\n%s\n", strings.Join(unfixable, "\n"))
+		return fmt.Errorf("Repaired %d LFS objects, %d are still broken", repaired, len(unfixable))
+	}
+
+	fmt.Printf("Successfully repaired %d LFS objects!\n", repaired)
+
+	return nil
+}
+
+// findObject returns the remote downloads use for the object: the first location which stores it with the
+// right size, otherwise the first one which stores it at all or the upload target if it is missing everywhere
+func findObject(router *internal.Router, p *internal.Pointer) (*internal.Remote, error) {
+	var found *internal.Remote
+
+	for _, r := range router.Locations(p.Oid, p.Size) {
+		size, err := r.ObjectSize(p.Oid)
+		if err != nil {
+			return nil, err
+		}
+
+		if size == p.Size {
+			return r, nil
+		}
+
+		if size >= 0 && found == nil {
+			found = r
+		}
+	}
+
+	if found == nil {
+		found = router.Target(p.Oid, p.Size)
+	}
+
+	return found, nil
+}
+
+// checkRemoteObject returns a description of what is wrong with the remote object or an empty string
+func checkRemoteObject(remote *internal.Remote, p *internal.Pointer, quick bool) (string, error) {
+	info, err := remote.StatObject(p.Oid)
+	if err != nil {
+		return "", err
+	}
+
+	var entry *internal.PackEntry
+	if info == nil {
+		entry, err = remote.FindPacked(p.Oid)
+		if err != nil {
+			return "", err
+		}
+
+		if entry == nil {
+			return "missing", nil
+		}
+	}
+
+	var size int64
+	if info != nil {
+		if info.IsDir {
+			return "not a regular file", nil
+		}
+
+		size = info.Size
+	} else {
+		size = entry.Size
+	}
+
+	if size != p.Size {
+		return fmt.Sprintf("expected size %d but got %d", p.Size, size), nil
+	}
+
+	// The metadata comes with the folder listing, so this is checked even in quick mode
+	if info != nil && info.Meta != nil {
+		problem, modified := info.Meta.Check(p.Oid, info)
+		if len(problem) > 0 && (!modified || quick) {
+			return problem, nil
+		}
+	}
+
+	if quick {
+		return "", nil
+	}
+
+	var reader io.ReadCloser
+	if info != nil {
+		reader, err = remote.ReadStream(info.Path)
+	} else {
+		reader, err = remote.ReadPacked(entry)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to read object %s: %v", p.Oid, err)
+	}
+
+	defer reader.Close()
+
+	err = internal.VerifyObject(reader, p.Oid, p.Size)
+	if err != nil {
+		return err.Error(), nil
+	}
+
+	return "", nil
+}
+
+// reuploadObject uploads the local copy as a loose object, which takes precedence over a broken packed one
+func reuploadObject(remote *internal.Remote, gitPath string, p *internal.Pointer) error {
+	info, err := remote.StatObject(p.Oid)
+	if err != nil {
+		return err
+	}
+
+	if info != nil && info.IsDir {
+		return fmt.Errorf("%q is a folder", info.Path)
+	}
+
+	err = remote.EnsureDir(remote.Layout.ObjectDir(p.Oid))
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(internal.LocalObjectPath(gitPath, p.Oid))
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if info == nil {
+		return remote.CreateObject(p.Oid, file, p.Size)
+	}
+
+	// Only replace the file if it hasn't been changed in the meantime
+	return remote.ReplaceObject(p.Oid, file, p.Size, info.ETag)
+}
@@ -0,0 +1,318 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// The upper bounds of the size histogram (the last bucket has no upper bound)
+var statsBuckets = []int64{1 << 10, 16 << 10, 256 << 10, 4 << 20, 64 << 20, 1 << 30}
+
+type statsObject struct {
+	Oid      string    `json:"oid"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Packed   bool      `json:"packed"`
+}
+
+type statsBucket struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+	Bytes int64  `json:"bytes"`
+
+	// The exclusive upper bound (0 for the last bucket)
+	MaxSize int64 `json:"maxSize,omitempty"`
+}
+
+type statsMonth struct {
+	Month string `json:"month"`
+	Count int    `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+type statsReport struct {
+	URLs          []string       `json:"urls"`
+	Objects       int            `json:"objects"`
+	ObjectBytes   int64          `json:"objectBytes"`
+	LooseObjects  int            `json:"looseObjects"`
+	PackedObjects int            `json:"packedObjects"`
+	Packs         int            `json:"packs"`
+	StoredBytes   int64          `json:"storedBytes"`
+	Histogram     []*statsBucket `json:"histogram"`
+	Largest       []*statsObject `json:"largest"`
+	Months        []*statsMonth  `json:"months"`
+
+	// Only set inside a repository
+	Unreferenced      []*statsObject `json:"unreferenced,omitempty"`
+	UnreferencedBytes int64          `json:"unreferencedBytes,omitempty"`
+}
+
+// Stats executes the stats command
+func Stats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Print the statistics as JSON")
+	top := flags.Int("top", 10, "Number of largest objects to list")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("Usage: git-lfs-webdav stats [--json] [--top <n>]")
+	}
+
+	if *top < 0 {
+		return fmt.Errorf("Invalid value %d for --top, expected a number of objects >= 0", *top)
+	}
+
+	router, err := internal.OpenRouter()
+	if err != nil {
+		return err
+	}
+
+	objects, report, err := collectStats(router.Remotes())
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Size > objects[j].Size
+	})
+
+	if *top > len(objects) {
+		*top = len(objects)
+	}
+
+	report.Largest = objects[:*top]
+
+	// Outside of a repository there are no refs to compare with
+	if _, err := internal.GitGetPath(); err == nil {
+		pointers, err := internal.GitLFSPointers("--all")
+		if err != nil {
+			return err
+		}
+
+		referenced := make(map[string]bool)
+		for _, p := range pointers {
+			referenced[p.Oid] = true
+		}
+
+		report.Unreferenced = make([]*statsObject, 0)
+		for _, o := range objects {
+			if !referenced[o.Oid] {
+				report.Unreferenced = append(report.Unreferenced, o)
+				report.UnreferencedBytes += o.Size
+			}
+		}
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(report)
+	}
+
+	printStats(report)
+
+	return nil
+}
+
+// collectStats lists all loose and packed objects on the servers (every oid only once, loose ones take precedence)
+func collectStats(remotes []*internal.Remote) ([]*statsObject, *statsReport, error) {
+	report := &statsReport{URLs: make([]string, 0, len(remotes))}
+	objects := make(map[string]*statsObject)
+
+	for _, remote := range remotes {
+		report.URLs = append(report.URLs, remote.URL.String())
+
+		err := collectLooseStats(remote, objects, report)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, remote := range remotes {
+		err := collectPackedStats(remote, objects, report)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	report.Histogram = make([]*statsBucket, len(statsBuckets)+1)
+	for i := range report.Histogram {
+		if i < len(statsBuckets) {
+			report.Histogram[i] = &statsBucket{Label: "< " + internal.FormatSize(statsBuckets[i]), MaxSize: statsBuckets[i]}
+		} else {
+			report.Histogram[i] = &statsBucket{Label: ">= " + internal.FormatSize(statsBuckets[i-1])}
+		}
+	}
+
+	months := make(map[string]*statsMonth)
+
+	list := make([]*statsObject, 0, len(objects))
+	for _, o := range objects {
+		list = append(list, o)
+
+		report.Objects++
+		report.ObjectBytes += o.Size
+
+		i := sort.Search(len(statsBuckets), func(i int) bool {
+			return o.Size < statsBuckets[i]
+		})
+		report.Histogram[i].Count++
+		report.Histogram[i].Bytes += o.Size
+
+		month := "unknown"
+		if !o.Modified.IsZero() {
+			month = o.Modified.UTC().Format("2006-01")
+		}
+
+		if months[month] == nil {
+			months[month] = &statsMonth{Month: month}
+		}
+		months[month].Count++
+		months[month].Bytes += o.Size
+	}
+
+	report.Months = make([]*statsMonth, 0, len(months))
+	for _, m := range months {
+		report.Months = append(report.Months, m)
+	}
+
+	sort.Slice(report.Months, func(i, j int) bool {
+		return report.Months[i].Month < report.Months[j].Month
+	})
+
+	return list, report, nil
+}
+
+// collectLooseStats adds the loose objects of a server (objects stored on several servers are counted once)
+func collectLooseStats(remote *internal.Remote, objects map[string]*statsObject, report *statsReport) error {
+	return remote.WalkObjects(remote.Layout, func(oid string, info *internal.RemoteFile) error {
+		if info.IsDir {
+			return nil
+		}
+
+		report.StoredBytes += info.Size
+
+		if _, ok := objects[oid]; !ok {
+			objects[oid] = &statsObject{Oid: oid, Size: info.Size, Modified: info.ModTime}
+			report.LooseObjects++
+		}
+
+		return nil
+	})
+}
+
+// collectPackedStats adds the packed objects of a server which aren't stored loose anywhere
+func collectPackedStats(remote *internal.Remote, objects map[string]*statsObject, report *statsReport) error {
+	packs, err := remote.PackEntries()
+	if err != nil {
+		return err
+	}
+
+	if len(packs) < 1 {
+		return nil
+	}
+
+	// The objects inside a pack have been uploaded together with the pack
+	packFiles, err := remote.ReadDir(remote.PackDir())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range packFiles {
+		if !f.IsDir {
+			report.StoredBytes += f.Size
+		}
+	}
+
+	modified := make(map[string]time.Time)
+	for _, f := range packFiles {
+		if strings.HasSuffix(f.Path, ".pack") {
+			modified[strings.TrimSuffix(path.Base(f.Path), ".pack")] = f.ModTime
+			report.Packs++
+		}
+	}
+
+	for name, entries := range packs {
+		for _, e := range entries {
+			if _, ok := objects[e.Oid]; !ok {
+				objects[e.Oid] = &statsObject{Oid: e.Oid, Size: e.Size, Modified: modified[name], Packed: true}
+				report.PackedObjects++
+			}
+		}
+	}
+
+	return nil
+}
+
+func printStats(report *statsReport) {
+	fmt.Printf("LFS objects on %s\n\n", strings.Join(report.URLs, ", "))
+	fmt.Printf("  Objects:        %d (%s)\n", report.Objects, internal.FormatSize(report.ObjectBytes))
+	fmt.Printf("  Loose objects:  %d\n", report.LooseObjects)
+	fmt.Printf("  Packed objects: %d in %d packs\n", report.PackedObjects, report.Packs)
+	fmt.Printf("  Stored on disk: %s\n", internal.FormatSize(report.StoredBytes))
+
+	fmt.Printf("\nSize histogram\n\n")
+	for _, b := range report.Histogram {
+		fmt.Printf("  %-12s %8d %12s\n", b.Label, b.Count, internal.FormatSize(b.Bytes))
+	}
+
+	if len(report.Largest) > 0 {
+		fmt.Printf("\nLargest objects\n\n")
+		for _, o := range report.Largest {
+			fmt.Printf("  %s %12s\n", o.Oid, internal.FormatSize(o.Size))
+		}
+	}
+
+	if len(report.Months) > 0 {
+		fmt.Printf("\nGrowth by month\n\n")
+
+		var cumulative int64
+		for _, m := range report.Months {
+			cumulative += m.Bytes
+			fmt.Printf("  %-8s %8d %12s %12s total\n", m.Month, m.Count, internal.FormatSize(m.Bytes), internal.FormatSize(cumulative))
+		}
+	}
+
+	if report.Unreferenced != nil {
+		fmt.Printf("\nUnreferenced objects (not used by any ref of this repository): %d (%s)\n", len(report.Unreferenced), internal.FormatSize(report.UnreferencedBytes))
+		if len(report.Unreferenced) > 0 {
+			fmt.Println("")
+		}
+
+		for _, o := range report.Unreferenced {
+			fmt.Printf("  %s %12s\n", o.Oid, internal.FormatSize(o.Size))
+		}
+	}
+}
@@ -0,0 +1,156 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// statusObject is an object found locally and/or referenced by the refs
+type statusObject struct {
+	oid        string
+	size       int64
+	local      bool
+	referenced bool
+}
+
+// Status executes the status command
+func Status(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	verbose := flags.Bool("verbose", false, "Also list the objects which exist on both sides or only on the server")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	refs := flags.Args()
+	if len(refs) < 1 {
+		refs = []string{"HEAD"}
+	}
+
+	gitPath, err := internal.GitGetPath()
+	if err != nil {
+		return err
+	}
+
+	objects := make(map[string]*statusObject)
+
+	local, err := internal.LocalObjects(gitPath)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range local {
+		objects[o.Oid] = &statusObject{oid: o.Oid, size: o.Size, local: true}
+	}
+
+	// Like 'fetch' only the files of the commits the refs point to are compared
+	pointers, err := internal.GitLFSPointers(append([]string{"--no-walk"}, refs...)...)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pointers {
+		if o, ok := objects[p.Oid]; ok {
+			o.referenced = true
+		} else {
+			objects[p.Oid] = &statusObject{oid: p.Oid, size: p.Size, referenced: true}
+		}
+	}
+
+	router, err := internal.OpenRouter()
+	if err != nil {
+		return err
+	}
+
+	oids := make([]string, 0, len(objects))
+	for oid := range objects {
+		oids = append(oids, oid)
+	}
+
+	sort.Strings(oids)
+
+	var both, remoteOnly, localOnly, mismatch, missing []string
+
+	// The folder listings and pack indexes are cached, so this only costs a request per folder
+	for _, oid := range oids {
+		o := objects[oid]
+
+		remoteSize, err := router.ObjectSize(oid, o.size)
+		if err != nil {
+			return err
+		}
+
+		line := fmt.Sprintf("  %s %12s", oid, internal.FormatSize(o.size))
+
+		switch {
+		case remoteSize >= 0 && remoteSize != o.size:
+			mismatch = append(mismatch, fmt.Sprintf("%s (%d bytes on the server)", line, remoteSize))
+		case remoteSize >= 0 && o.local:
+			both = append(both, line)
+		case remoteSize >= 0:
+			remoteOnly = append(remoteOnly, line)
+		case o.local:
+			localOnly = append(localOnly, line)
+		default:
+			missing = append(missing, line)
+		}
+	}
+
+	fmt.Printf("Compared %d local and %d referenced LFS objects with %s\n\n", len(local), len(pointers), router.Primary.URL)
+	fmt.Printf("  Present in both:     %d\n", len(both))
+	fmt.Printf("  Only on the server:  %d\n", len(remoteOnly))
+	fmt.Printf("  Only local:          %d\n", len(localOnly))
+	fmt.Printf("  Size mismatch:       %d\n", len(mismatch))
+	fmt.Printf("  Missing everywhere:  %d\n", len(missing))
+
+	printStatusList("Only local (not pushed)", localOnly)
+	printStatusList("Size mismatch (probably corrupt on the server, see 'git-lfs-webdav repair')", mismatch)
+	printStatusList("Missing everywhere (referenced but neither local nor on the server)", missing)
+
+	if *verbose {
+		printStatusList("Only on the server", remoteOnly)
+		printStatusList("Present in both", both)
+	}
+
+	// The exit code tells scripts whether something would be lost without this machine
+	if len(localOnly) > 0 || len(mismatch) > 0 {
+		return fmt.Errorf("%d LFS objects only exist locally and %d don't match the server, run 'git-lfs-webdav push --all' or 'git-lfs-webdav repair'",
+			len(localOnly), len(mismatch))
+	}
+
+	return nil
+}
+
+func printStatusList(title string, lines []string) {
+	if len(lines) < 1 {
+		return
+	}
+
+	fmt.Printf("\n%s:\n", title)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
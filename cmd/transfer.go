@@ -19,9 +19,21 @@
 
 package cmd
 
-import "github.com/mpotthoff/git-lfs-webdav/internal"
+import (
+	"flag"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
 
 // Transfer executes the transfer command
 func Transfer(args []string) error {
-	return internal.Processor()
+	flags := flag.NewFlagSet("transfer", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "Overwrite remote objects which have the wrong size")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	return internal.Processor(*repair)
 }
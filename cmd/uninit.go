@@ -0,0 +1,214 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"path/filepath"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+// uninit removes settings and reports every removal (or only reports them in a dry run)
+type uninit struct {
+	dryRun  bool
+	changed int
+}
+
+func (u *uninit) report(format string, a ...interface{}) {
+	u.changed++
+
+	if u.dryRun {
+		fmt.Printf("Would remove %s\n", fmt.Sprintf(format, a...))
+	} else {
+		fmt.Printf("Removed %s\n", fmt.Sprintf(format, a...))
+	}
+}
+
+// unsetLocal removes a setting from .git/config if it is set there and accepted by the filter
+func (u *uninit) unsetLocal(gitConfig string, name string, filter func(value string) bool) error {
+	value, _ := internal.GitConfigFileGet(gitConfig, name)
+	if len(value) < 1 || (filter != nil && !filter(value)) {
+		return nil
+	}
+
+	if !u.dryRun {
+		err := internal.GitConfigUnset(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	u.report("%s from .git/config", name)
+
+	return nil
+}
+
+// unsetLFSConfig removes a setting from .lfsconfig if it is set there
+func (u *uninit) unsetLFSConfig(name string) error {
+	value, _ := internal.LFSConfigFileGet(name)
+	if len(value) < 1 {
+		return nil
+	}
+
+	if !u.dryRun {
+		err := internal.LFSConfigFileUnset(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	u.report("%s from %s", name, internal.LFSConfigFile)
+
+	return nil
+}
+
+// forgetCredentials removes the credentials stored by 'login' (like 'logout')
+func (u *uninit) forgetCredentials() error {
+	lfsURL, err := getLoginURL()
+	if err != nil {
+		return err
+	}
+
+	if helper := internal.GitCredentialHelper(lfsURL.String()); len(helper) > 0 {
+		creds := make(internal.Creds)
+		creds["url"] = lfsURL.String()
+
+		// Only report the credentials if the helper actually has some
+		stored, err := internal.GitCredentialLookup(creds)
+		if err != nil {
+			return err
+		}
+
+		if stored != nil {
+			if !u.dryRun {
+				err = internal.GitCredentialReject(creds)
+				if err != nil {
+					return err
+				}
+			}
+
+			u.report("the credentials for %s from the credential helper %q", lfsURL, helper)
+		}
+	}
+
+	if u.dryRun {
+		creds, err := internal.LoadStoredCredentials(lfsURL.String())
+		if err != nil {
+			return err
+		}
+
+		if creds != nil {
+			u.report("the credentials for %s from the credential store", lfsURL)
+		}
+
+		return nil
+	}
+
+	removed, err := internal.RemoveStoredCredentials(lfsURL.String())
+	if err != nil {
+		return err
+	}
+
+	if removed {
+		u.report("the credentials for %s from the credential store", lfsURL)
+	}
+
+	return nil
+}
+
+// Uninit executes the uninit command
+func Uninit(args []string) error {
+	flags := flag.NewFlagSet("uninit", flag.ContinueOnError)
+	lfsConfig := flags.Bool("lfsconfig", false, "Also remove the URL and the detected capabilities from .lfsconfig")
+	credentials := flags.Bool("credentials", false, "Also remove the credentials stored by 'login'")
+	dryRun := flags.Bool("dry-run", false, "Only report what would be removed")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("Usage: git-lfs-webdav uninit [--lfsconfig] [--credentials] [--dry-run]")
+	}
+
+	gitPath, err := internal.GitGetPath()
+	if err != nil {
+		return err
+	}
+
+	gitConfig := filepath.Join(gitPath, "config")
+
+	u := &uninit{dryRun: *dryRun}
+
+	// The credentials are stored for the URL, so they have to be removed before the URL
+	if *credentials {
+		err = u.forgetCredentials()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Settings written by init
+	err = u.unsetLocal(gitConfig, "lfs.customtransfer.webdav.path", nil)
+	if err == nil {
+		err = u.unsetLocal(gitConfig, "lfs.customtransfer.webdav.args", nil)
+	}
+	if err == nil {
+		err = u.unsetLocal(gitConfig, "lfs.standalonetransferagent", func(value string) bool {
+			return value == "webdav"
+		})
+	}
+
+	// The URL with cleartext credentials written by older versions of login
+	if err == nil {
+		err = u.unsetLocal(gitConfig, "lfs.url", func(value string) bool {
+			parsed, err := url.Parse(value)
+			return err == nil && parsed.User != nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if *lfsConfig {
+		for _, name := range []string{"lfs.url", "lfs.webdav.ranges", "lfs.webdav.deadProperties"} {
+			err = u.unsetLFSConfig(name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if u.changed < 1 {
+		fmt.Println("LFS WebDAV is not configured in this repository.")
+	} else if u.dryRun {
+		fmt.Println("Nothing has been changed (dry run).")
+	} else if *lfsConfig {
+		fmt.Println("Successfully removed LFS WebDAV! Commit .lfsconfig to remove it for everyone.")
+	} else {
+		fmt.Println("Successfully removed LFS WebDAV from this repository.")
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/mpotthoff/git-lfs-webdav/internal"
+)
+
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// The hook runs the hook of Git LFS first (which uploads the objects) and verifies the result afterwards.
+// Both need the refs which git passes on stdin.
+const prePushHook = `#!/bin/sh
+command -v git-lfs >/dev/null 2>&1 || { echo >&2 "This repository is configured for Git LFS but 'git-lfs' was not found on your path."; exit 2; }
+input="$(cat)"
+printf '%%s\n' "$input" | git lfs pre-push "$@" || exit $?
+printf '%%s\n' "$input" | %s verify-push --hook "$@"
+`
+
+// VerifyPush executes the verify-push command
+func VerifyPush(args []string) error {
+	flags := flag.NewFlagSet("verify-push", flag.ContinueOnError)
+	hook := flags.Bool("hook", false, "Read the refs to push from stdin like a pre-push hook")
+	install := flags.Bool("install", false, "Install the pre-push hook")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *install {
+		return installPrePushHook()
+	}
+
+	remoteName := "origin"
+	if flags.NArg() > 0 {
+		remoteName = flags.Arg(0)
+	}
+
+	revs := make([]string, 0)
+	if *hook {
+		// Every line is "<local ref> <local sha1> <remote ref> <remote sha1>"
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 4 && fields[1] != zeroSHA {
+				revs = append(revs, fields[1])
+			}
+		}
+
+		err = scanner.Err()
+		if err != nil {
+			return err
+		}
+	} else if flags.NArg() > 1 {
+		revs = flags.Args()[1:]
+	} else {
+		revs = append(revs, "HEAD")
+	}
+
+	if len(revs) < 1 {
+		// Only deleted refs
+		return nil
+	}
+
+	// Only check the commits which the remote doesn't have yet
+	pointers, err := internal.GitLFSPointers(append(revs, "--not", "--remotes="+remoteName)...)
+	if err != nil {
+		return err
+	}
+
+	if len(pointers) < 1 {
+		return nil
+	}
+
+	router, err := internal.OpenRouter()
+	if err != nil {
+		return err
+	}
+
+	problems := make([]string, 0)
+	for _, p := range pointers {
+		// This uses the cached folder listings instead of a request per object
+		size, err := router.ObjectSize(p.Oid, p.Size)
+		if err != nil {
+			return err
+		}
+
+		if size < 0 {
+			problems = append(problems, fmt.Sprintf("  %s (missing)", p.Oid))
+		} else if size != p.Size {
+			problems = append(problems, fmt.Sprintf("  %s (expected size %d but got %d)", p.Oid, p.Size, size))
+		}
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "The following LFS objects are not available on the WebDAV server:\n%s\n", strings.Join(problems, "\n"))
+		return fmt.Errorf("Refusing to push because %d of %d LFS objects are missing on the server", len(problems), len(pointers))
+	}
+
+	if !*hook {
+		fmt.Printf("All %d LFS objects are available on the server.\n", len(pointers))
+	}
+
+	return nil
+}
+
+func installPrePushHook() error {
+	hookPath, err := internal.GitGetHookPath("pre-push")
+	if err != nil {
+		return err
+	}
+
+	// Hooks run in the top level of the working tree, so a relative path wouldn't work from a subfolder
+	agentPath, err := agentExecutable()
+	if err != nil {
+		return err
+	}
+
+	existing, err := ioutil.ReadFile(hookPath)
+	if err == nil {
+		content := string(existing)
+
+		// Only replace the unmodified hook installed by Git LFS (or our own)
+		isLFS := strings.Contains(content, "git lfs pre-push") && strings.Count(strings.TrimSpace(content), "\n") <= 3
+		isOurs := strings.Contains(content, "verify-push --hook")
+		if !isLFS && !isOurs {
+			return fmt.Errorf("%q already exists and has been modified. Add the following line after 'git lfs pre-push' yourself:\n  %s verify-push --hook \"$@\"\n(both commands need the input of the hook)", hookPath, quoteShell(agentPath))
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	err = ioutil.WriteFile(hookPath, []byte(fmt.Sprintf(prePushHook, quoteShell(agentPath))), 0755)
+	if err != nil {
+		return fmt.Errorf("Failed to write %q: %v", hookPath, err)
+	}
+
+	fmt.Printf("Successfully installed the pre-push hook in %q!\n", hookPath)
+
+	return nil
+}
+
+func quoteShell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
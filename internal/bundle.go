@@ -0,0 +1,178 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A bundle moves LFS objects without a network connection (like 'git bundle' for the git objects).
+// It is a tar archive which starts with "index" (a header line and one "<oid> <size>" line per object)
+// followed by one "objects/<oid>" entry per object in the same order.
+const (
+	bundleHeader    = "git-lfs-webdav bundle v1"
+	bundleIndexName = "index"
+)
+
+// BundleWriter writes a bundle
+type BundleWriter struct {
+	tar     *tar.Writer
+	objects []*Pointer
+	next    int
+}
+
+// NewBundleWriter starts a bundle with the given objects. They have to be added afterwards in the same order.
+func NewBundleWriter(w io.Writer, objects []*Pointer) (*BundleWriter, error) {
+	index := new(bytes.Buffer)
+	index.WriteString(bundleHeader + "\n")
+	for _, o := range objects {
+		fmt.Fprintf(index, "%s %d\n", o.Oid, o.Size)
+	}
+
+	bw := &BundleWriter{tar: tar.NewWriter(w), objects: objects}
+
+	err := bw.writeEntry(bundleIndexName, index, int64(index.Len()))
+	if err != nil {
+		return nil, err
+	}
+
+	return bw, nil
+}
+
+func (bw *BundleWriter) writeEntry(name string, reader io.Reader, size int64) error {
+	err := bw.tar.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now(), Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(bw.tar, io.LimitReader(reader, size))
+	if err == nil && n != size {
+		err = fmt.Errorf("expected size %v but got %v", size, n)
+	}
+
+	return err
+}
+
+// Add writes the next object of the index
+func (bw *BundleWriter) Add(reader io.Reader) error {
+	if bw.next >= len(bw.objects) {
+		return fmt.Errorf("All objects have been added to the bundle already")
+	}
+
+	o := bw.objects[bw.next]
+	bw.next++
+
+	err := bw.writeEntry("objects/"+o.Oid, reader, o.Size)
+	if err != nil {
+		return fmt.Errorf("Failed to add object %s to the bundle: %v", o.Oid, err)
+	}
+
+	return nil
+}
+
+// Close finishes the bundle
+func (bw *BundleWriter) Close() error {
+	if bw.next < len(bw.objects) {
+		return fmt.Errorf("Only %d of %d objects have been added to the bundle", bw.next, len(bw.objects))
+	}
+
+	return bw.tar.Close()
+}
+
+// BundleReader reads a bundle
+type BundleReader struct {
+	// Objects are the objects listed in the index
+	Objects []*Pointer
+
+	tar  *tar.Reader
+	next int
+}
+
+// OpenBundle reads the index of a bundle
+func OpenBundle(r io.Reader) (*BundleReader, error) {
+	br := &BundleReader{tar: tar.NewReader(r)}
+
+	header, err := br.tar.Next()
+	if err != nil || header.Name != bundleIndexName {
+		return nil, fmt.Errorf("Not a git-lfs-webdav bundle")
+	}
+
+	scanner := bufio.NewScanner(br.tar)
+	if !scanner.Scan() || scanner.Text() != bundleHeader {
+		return nil, fmt.Errorf("Unsupported bundle format")
+	}
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || !ValidOid(fields[0]) {
+			return nil, fmt.Errorf("Invalid bundle index line %q", scanner.Text())
+		}
+
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("Invalid bundle index line %q", scanner.Text())
+		}
+
+		br.Objects = append(br.Objects, &Pointer{Oid: fields[0], Size: size})
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the bundle index: %v", err)
+	}
+
+	return br, nil
+}
+
+// Next returns the next object and a reader for its content (io.EOF after the last one).
+// The content is not verified, use StoreLocalObject or VerifyObject.
+func (br *BundleReader) Next() (*Pointer, io.Reader, error) {
+	header, err := br.tar.Next()
+	if err == io.EOF {
+		if br.next < len(br.Objects) {
+			return nil, nil, fmt.Errorf("The bundle is truncated, %d of %d objects are missing", len(br.Objects)-br.next, len(br.Objects))
+		}
+
+		return nil, nil, io.EOF
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read the bundle: %v", err)
+	}
+
+	if br.next >= len(br.Objects) {
+		return nil, nil, fmt.Errorf("Unexpected entry %q in the bundle", header.Name)
+	}
+
+	o := br.Objects[br.next]
+	br.next++
+
+	if header.Name != path.Join("objects", o.Oid) || header.Size != o.Size {
+		return nil, nil, fmt.Errorf("Entry %q of the bundle doesn't match the index (expected %s with %d bytes)", header.Name, o.Oid, o.Size)
+	}
+
+	return o, br.tar, nil
+}
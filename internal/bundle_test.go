@@ -0,0 +1,144 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func writeTestBundle(t *testing.T, contents []string) ([]byte, []*Pointer) {
+	objects := make([]*Pointer, len(contents))
+	for i, content := range contents {
+		oid, size := testObject(content)
+		objects[i] = &Pointer{Oid: oid, Size: size}
+	}
+
+	buf := new(bytes.Buffer)
+	bw, err := NewBundleWriter(buf, objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range contents {
+		err = bw.Add(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = bw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes(), objects
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	contents := []string{"first object", "", "third object"}
+	data, objects := writeTestBundle(t, contents)
+
+	br, err := OpenBundle(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(br.Objects) != len(objects) {
+		t.Fatalf("Expected %d objects in the index but got %d", len(objects), len(br.Objects))
+	}
+
+	for i := range contents {
+		o, reader, err := br.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if *o != *objects[i] {
+			t.Errorf("Expected object %+v but got %+v", objects[i], o)
+		}
+
+		err = VerifyObject(reader, o.Oid, o.Size)
+		if err != nil {
+			t.Errorf("Object %d is invalid: %v", i, err)
+		}
+	}
+
+	_, _, err = br.Next()
+	if err != io.EOF {
+		t.Errorf("Expected io.EOF after the last object but got %v", err)
+	}
+}
+
+func TestBundleWriterErrors(t *testing.T) {
+	oid, size := testObject("content")
+	objects := []*Pointer{{Oid: oid, Size: size}}
+
+	bw, _ := NewBundleWriter(ioutil.Discard, objects)
+	if bw.Close() == nil {
+		t.Error("Expected an error when closing a bundle with missing objects")
+	}
+
+	bw, _ = NewBundleWriter(ioutil.Discard, objects)
+	if bw.Add(strings.NewReader("short")) == nil {
+		t.Error("Expected an error for an object with the wrong size")
+	}
+
+	bw, _ = NewBundleWriter(ioutil.Discard, objects)
+	bw.Add(strings.NewReader("content"))
+	if bw.Add(strings.NewReader("content")) == nil {
+		t.Error("Expected an error for more objects than in the index")
+	}
+}
+
+func TestBundleTruncated(t *testing.T) {
+	data, _ := writeTestBundle(t, []string{"first object", "second object"})
+
+	// Cut off the second object (tar entries are padded to 512 bytes, the end marker has 1024)
+	br, err := OpenBundle(bytes.NewReader(data[:len(data)-1024-1024]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = br.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = br.Next()
+	if err == nil || err == io.EOF {
+		t.Errorf("Expected an error for a truncated bundle but got %v", err)
+	}
+}
+
+func TestOpenBundleInvalid(t *testing.T) {
+	_, err := OpenBundle(strings.NewReader("not a tar archive"))
+	if err == nil {
+		t.Error("Expected an error for a file which isn't a bundle")
+	}
+
+	_, err = OpenBundle(bytes.NewReader(nil))
+	if err == nil {
+		t.Error("Expected an error for an empty file")
+	}
+}
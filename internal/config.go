@@ -0,0 +1,142 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"gib", 1 << 30}, {"gb", 1 << 30}, {"g", 1 << 30},
+	{"mib", 1 << 20}, {"mb", 1 << 20}, {"m", 1 << 20},
+	{"kib", 1 << 10}, {"kb", 1 << 10}, {"k", 1 << 10},
+	{"b", 1},
+}
+
+// LFSConfigGet gets the value of a setting which can be stored in the git config or in .lfsconfig.
+// Like Git LFS the git config takes precedence over .lfsconfig.
+func LFSConfigGet(name string) (string, error) {
+	value, err := GitConfigGet(name)
+	if len(value) > 0 {
+		return value, nil
+	}
+
+	value, err2 := LFSConfigFileGet(name)
+	if len(value) > 0 {
+		return value, nil
+	}
+
+	if err != nil || err2 != nil {
+		return "", fmt.Errorf("%v\n%v", err, err2)
+	}
+
+	return "", nil
+}
+
+// LFSConfigGetOptional works like LFSConfigGet but returns an empty string if the setting is not set
+func LFSConfigGetOptional(name string) string {
+	value, _ := LFSConfigGet(name)
+	return value
+}
+
+// LFSConfigGetAll gets every value of a multi-valued setting. The values in the git config replace
+// the ones in .lfsconfig instead of being combined with them.
+func LFSConfigGetAll(name string) []string {
+	values := GitConfigGetAll(name)
+	if len(values) > 0 {
+		return values
+	}
+
+	return LFSConfigFileGetAll(name)
+}
+
+// ParseSize parses a size like "512", "64KB" or "1.5g" (units are multiples of 1024 like in git)
+func ParseSize(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	factor := int64(1)
+
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("Invalid size %q", s)
+	}
+
+	return int64(number * float64(factor)), nil
+}
+
+// LFSConfigGetSize gets a size setting (see ParseSize) or the given default if it is not set
+func LFSConfigGetSize(name string, def int64) (int64, error) {
+	value := LFSConfigGetOptional(name)
+	if len(value) < 1 {
+		return def, nil
+	}
+
+	size, err := ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value for %s: %v", name, err)
+	}
+
+	return size, nil
+}
+
+// LFSConfigGetBool gets a boolean setting (true/yes/on/1 or false/no/off/0 like in git) or the given default if it is not set
+func LFSConfigGetBool(name string, def bool) (bool, error) {
+	value := strings.ToLower(strings.TrimSpace(LFSConfigGetOptional(name)))
+
+	switch value {
+	case "":
+		return def, nil
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("Invalid value for %s: %q is not a boolean", name, value)
+}
+
+// FormatSize formats a number of bytes using binary units (e.g. "1.5 MiB")
+func FormatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB"} {
+		value /= 1024
+		if value < 1024 || unit == "GiB" {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,74 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"0":       0,
+		"512":     512,
+		"512b":    512,
+		"64KB":    64 << 10,
+		"64 kib":  64 << 10,
+		"1.5g":    3 << 29,
+		" 2MiB ":  2 << 20,
+		"256k":    256 << 10,
+		"1GB":     1 << 30,
+		"0.5 mb":  1 << 19,
+		"100 B":   100,
+		"10.0KiB": 10 << 10,
+	}
+
+	for s, expected := range tests {
+		size, err := ParseSize(s)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", s, err)
+		} else if size != expected {
+			t.Errorf("Expected %d for %q but got %d", expected, s, size)
+		}
+	}
+
+	for _, s := range []string{"", "abc", "-1", "12 TB", "1.2.3k", "k"} {
+		_, err := ParseSize(s)
+		if err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1024:            "1.0 KiB",
+		1536:            "1.5 KiB",
+		64 << 20:        "64.0 MiB",
+		3 << 30:         "3.0 GiB",
+		2048 << 30:      "2048.0 GiB",
+		(1 << 20) - 512: "1023.5 KiB",
+	}
+
+	for n, expected := range tests {
+		if s := FormatSize(n); s != expected {
+			t.Errorf("Expected %q for %d but got %q", expected, n, s)
+		}
+	}
+}
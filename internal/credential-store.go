@@ -0,0 +1,394 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// If there is no git credential helper, 'login' stores the credentials in an encrypted file per user.
+// The file is encrypted with NaCl secretbox using either a random key file (which can be moved elsewhere
+// using lfs.webdav.credentialKeyFile) or a key derived from a passphrase with scrypt. The passphrase is read
+// from GIT_LFS_WEBDAV_PASSPHRASE or asked for on the terminal.
+const (
+	credentialStoreVersion = 1
+
+	keyTypeKeyFile    = "keyfile"
+	keyTypePassphrase = "passphrase"
+
+	// PassphraseEnv is the environment variable which contains the passphrase of the credential store
+	PassphraseEnv = "GIT_LFS_WEBDAV_PASSPHRASE"
+)
+
+type credentialFile struct {
+	Version int    `json:"version"`
+	KeyType string `json:"key"`
+	Salt    string `json:"salt,omitempty"`
+	Nonce   string `json:"nonce"`
+	Data    string `json:"data"`
+}
+
+type storedCredential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func credentialStoreDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "git-lfs-webdav"), nil
+}
+
+// CredentialStorePath returns the path of the encrypted credential file
+func CredentialStorePath() (string, error) {
+	dir, err := credentialStoreDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "credentials"), nil
+}
+
+func credentialKeyFilePath() (string, error) {
+	keyPath, _ := GitConfigGet("lfs.webdav.credentialKeyFile")
+	if len(keyPath) > 0 {
+		return keyPath, nil
+	}
+
+	dir, err := credentialStoreDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "credentials.key"), nil
+}
+
+// readKeyFile reads the key file and creates it if requested
+func readKeyFile(create bool) (*[32]byte, error) {
+	keyPath, err := credentialKeyFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	key := new([32]byte)
+
+	data, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) && create {
+		_, err = io.ReadFull(rand.Reader, key[:])
+		if err != nil {
+			return nil, err
+		}
+
+		err = os.MkdirAll(filepath.Dir(keyPath), 0700)
+		if err == nil {
+			err = ioutil.WriteFile(keyPath, []byte(base64.StdEncoding.EncodeToString(key[:])+"\n"), 0600)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create key file %q: %v", keyPath, err)
+		}
+
+		return key, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read key file %q: %v", keyPath, err)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(decoded) != len(key) {
+		return nil, fmt.Errorf("Key file %q is invalid", keyPath)
+	}
+
+	copy(key[:], decoded)
+	return key, nil
+}
+
+// readPassphrase gets the passphrase from the environment or from the terminal
+func readPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv(PassphraseEnv); len(passphrase) > 0 {
+		return passphrase, nil
+	}
+
+	// The transfer agent uses stdin for the protocol, so it can only use the environment
+	if !terminal.IsTerminal(int(syscall.Stdin)) {
+		return "", fmt.Errorf("The credential store is protected by a passphrase, set %s", PassphraseEnv)
+	}
+
+	fmt.Fprint(os.Stderr, "Passphrase for the credential store: ")
+	passphrase, err := terminal.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(os.Stderr, "")
+	if err != nil {
+		return "", fmt.Errorf("Failed to read passphrase: %v", err)
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat the passphrase: ")
+		repeated, err := terminal.ReadPassword(int(syscall.Stdin))
+		fmt.Fprintln(os.Stderr, "")
+		if err != nil {
+			return "", fmt.Errorf("Failed to read passphrase: %v", err)
+		}
+
+		if string(repeated) != string(passphrase) {
+			return "", fmt.Errorf("The passphrases don't match")
+		}
+	}
+
+	if len(passphrase) < 1 {
+		return "", fmt.Errorf("The passphrase must not be empty")
+	}
+
+	return string(passphrase), nil
+}
+
+func passphraseKey(passphrase string, salt []byte) (*[32]byte, error) {
+	derived, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	key := new([32]byte)
+	copy(key[:], derived)
+	return key, nil
+}
+
+// credentialStore is the decrypted content of the credential file
+type credentialStore struct {
+	path    string
+	keyType string
+	salt    []byte
+	key     *[32]byte
+	entries map[string]*storedCredential
+}
+
+// openCredentialStore decrypts the credential file (nil if it doesn't exist)
+func openCredentialStore() (*credentialStore, error) {
+	p, err := CredentialStorePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read credential store %q: %v", p, err)
+	}
+
+	file := &credentialFile{}
+	err = json.Unmarshal(data, file)
+	if err != nil || file.Version != credentialStoreVersion {
+		return nil, fmt.Errorf("Credential store %q has an unknown format", p)
+	}
+
+	store := &credentialStore{path: p, keyType: file.KeyType}
+
+	switch file.KeyType {
+	case keyTypeKeyFile:
+		store.key, err = readKeyFile(false)
+	case keyTypePassphrase:
+		store.salt, err = base64.StdEncoding.DecodeString(file.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("Credential store %q is corrupt", p)
+		}
+
+		var passphrase string
+		passphrase, err = readPassphrase(false)
+		if err == nil {
+			store.key, err = passphraseKey(passphrase, store.salt)
+		}
+	default:
+		err = fmt.Errorf("Credential store %q has an unknown key type %q", p, file.KeyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+	if err != nil || len(nonce) != 24 {
+		return nil, fmt.Errorf("Credential store %q is corrupt", p)
+	}
+
+	box, err := base64.StdEncoding.DecodeString(file.Data)
+	if err != nil {
+		return nil, fmt.Errorf("Credential store %q is corrupt", p)
+	}
+
+	var nonceArray [24]byte
+	copy(nonceArray[:], nonce)
+
+	plain, ok := secretbox.Open(nil, box, &nonceArray, store.key)
+	if !ok {
+		return nil, fmt.Errorf("Failed to decrypt credential store %q (wrong key or passphrase)", p)
+	}
+
+	err = json.Unmarshal(plain, &store.entries)
+	if err != nil {
+		return nil, fmt.Errorf("Credential store %q is corrupt", p)
+	}
+
+	return store, nil
+}
+
+// newCredentialStore creates an empty store which is protected by a passphrase or the key file
+func newCredentialStore(usePassphrase bool) (*credentialStore, error) {
+	p, err := CredentialStorePath()
+	if err != nil {
+		return nil, err
+	}
+
+	store := &credentialStore{path: p, keyType: keyTypeKeyFile, entries: make(map[string]*storedCredential)}
+
+	if usePassphrase {
+		store.keyType = keyTypePassphrase
+		store.salt = make([]byte, 16)
+
+		_, err = io.ReadFull(rand.Reader, store.salt)
+		if err != nil {
+			return nil, err
+		}
+
+		passphrase, err := readPassphrase(true)
+		if err != nil {
+			return nil, err
+		}
+
+		store.key, err = passphraseKey(passphrase, store.salt)
+	} else {
+		store.key, err = readKeyFile(true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *credentialStore) save() error {
+	plain, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+
+	var nonce [24]byte
+	_, err = io.ReadFull(rand.Reader, nonce[:])
+	if err != nil {
+		return err
+	}
+
+	file := &credentialFile{
+		Version: credentialStoreVersion,
+		KeyType: s.keyType,
+		Nonce:   base64.StdEncoding.EncodeToString(nonce[:]),
+		Data:    base64.StdEncoding.EncodeToString(secretbox.Seal(nil, plain, &nonce, s.key)),
+	}
+	if s.salt != nil {
+		file.Salt = base64.StdEncoding.EncodeToString(s.salt)
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return err
+	}
+
+	// Write a temporary file first so that the store is never left half written
+	tmpPath := s.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Failed to write credential store %q: %v", s.path, err)
+	}
+
+	return nil
+}
+
+// LoadStoredCredentials gets the credentials for the given URL from the credential store (nil if there are none)
+func LoadStoredCredentials(u string) (Creds, error) {
+	store, err := openCredentialStore()
+	if err != nil || store == nil {
+		return nil, err
+	}
+
+	entry, ok := store.entries[u]
+	if !ok {
+		return nil, nil
+	}
+
+	return Creds{"username": entry.Username, "password": entry.Password}, nil
+}
+
+// StoreCredentials saves the credentials for the given URL in the credential store.
+// A new store is protected by a passphrase if requested and by the key file otherwise.
+func StoreCredentials(u string, username string, password string, usePassphrase bool) error {
+	store, err := openCredentialStore()
+	if err != nil {
+		return err
+	}
+
+	if store == nil {
+		store, err = newCredentialStore(usePassphrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	store.entries[u] = &storedCredential{Username: username, Password: password}
+
+	return store.save()
+}
+
+// RemoveStoredCredentials removes the credentials for the given URL from the credential store
+// and reports whether there were any
+func RemoveStoredCredentials(u string) (bool, error) {
+	store, err := openCredentialStore()
+	if err != nil || store == nil {
+		return false, err
+	}
+
+	if _, ok := store.entries[u]; !ok {
+		return false, nil
+	}
+
+	delete(store.entries, u)
+
+	return true, store.save()
+}
@@ -43,7 +43,9 @@ func bufferCreds(creds Creds) *bytes.Buffer {
 	return buf
 }
 
-func execCredential(subcommand string, input Creds) (Creds, error) {
+// execCredential runs 'git credential'. A lookup only asks the credential helpers and doesn't print anything
+// if they don't have the credentials.
+func execCredential(subcommand string, input Creds, lookup bool) (Creds, error) {
 	output := new(bytes.Buffer)
 
 	cmd := exec.Command("git", "credential", subcommand)
@@ -52,6 +54,12 @@ func execCredential(subcommand string, input Creds) (Creds, error) {
 	cmd.Stdout = output
 	cmd.Stderr = os.Stderr
 
+	if lookup {
+		// Askpass programs would ask the user even though terminal prompts are disabled
+		cmd.Env = append(cmd.Env, "GIT_ASKPASS=false", "SSH_ASKPASS=false")
+		cmd.Stderr = nil
+	}
+
 	err := cmd.Start()
 	if err == nil {
 		err = cmd.Wait()
@@ -82,17 +90,28 @@ func execCredential(subcommand string, input Creds) (Creds, error) {
 
 // GitCredentialFill asks git to fill the given credentials
 func GitCredentialFill(creds Creds) (Creds, error) {
-	return execCredential("fill", creds)
+	return execCredential("fill", creds, false)
+}
+
+// GitCredentialLookup asks only the credential helpers for the given credentials (nil if they don't have them).
+// Unlike GitCredentialFill it never asks the user, not even using an askpass program.
+func GitCredentialLookup(creds Creds) (Creds, error) {
+	return execCredential("fill", creds, true)
 }
 
 // GitCredentialApprove marks the given credentials as approved
 func GitCredentialApprove(creds Creds) error {
-	_, err := execCredential("approve", creds)
+	_, err := execCredential("approve", creds, false)
 	return err
 }
 
 // GitCredentialReject marks the given credentials as rejected
 func GitCredentialReject(creds Creds) error {
-	_, err := execCredential("reject", creds)
+	_, err := execCredential("reject", creds, false)
 	return err
 }
+
+// GitCredentialHelper returns the credential helper configured for the given URL (empty if there is none)
+func GitCredentialHelper(u string) string {
+	return GitConfigGetURLMatch("credential.helper", u)
+}
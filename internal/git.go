@@ -22,40 +22,109 @@ package internal
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 )
 
-// GitGetPath gets the path of the '.git' directory
+// GitGetPath gets the path of the git directory which contains the 'lfs' folder.
+// In a linked worktree this is the directory shared by all worktrees (like Git LFS uses it).
 func GitGetPath() (string, error) {
-	output := new(bytes.Buffer)
+	output, err := gitOutput(nil, "rev-parse", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+
+	path := strings.TrimSpace(output)
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get absolute path of %s: %v", path, err)
+	}
+
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to evaluate symlinks of %s: %v", path, err)
+	}
+
+	return path, nil
+}
+
+// GitGetTopLevel gets the top level folder of the working tree (an empty string for bare repositories)
+func GitGetTopLevel() (string, error) {
+	output, err := gitOutput(nil, "rev-parse", "--is-inside-work-tree")
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(output) != "true" {
+		return "", nil
+	}
+
+	output, err = gitOutput(nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.FromSlash(strings.TrimSpace(output)), nil
+}
 
-	cmd := exec.Command("git", "rev-parse", "--git-dir")
+// GitRevExists checks whether the given revision (e.g. "HEAD:.lfsconfig") exists without printing errors
+func GitRevExists(rev string) bool {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", rev)
 	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
-	cmd.Stdout = output
-	cmd.Stderr = os.Stderr
 
-	err := cmd.Start()
-	if err == nil {
-		err = cmd.Wait()
+	return cmd.Run() == nil
+}
+
+// GitRecentRefs returns the names of the refs matching the patterns (all branches if there are none)
+// sorted by the date of their last commit, newest first. At most count refs are returned unless count is 0.
+func GitRecentRefs(count int, patterns ...string) ([]string, error) {
+	if len(patterns) < 1 {
+		patterns = []string{"refs/heads", "refs/remotes"}
 	}
 
+	args := []string{"for-each-ref", "--sort=-committerdate", "--format=%(refname)"}
+
+	output, err := gitOutput(nil, append(args, patterns...)...)
 	if err != nil {
-		return "", fmt.Errorf("'git rev-parse --git-dir' failed with: %v", err)
+		return nil, err
 	}
 
-	path := strings.TrimSpace(output.String())
+	refs := make([]string, 0)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
 
-	path, err = filepath.Abs(path)
+		// Symbolic refs like refs/remotes/origin/HEAD are just duplicates
+		if len(line) > 0 && !strings.HasSuffix(line, "/HEAD") {
+			refs = append(refs, line)
+		}
+	}
+
+	if count > 0 && len(refs) > count {
+		refs = refs[:count]
+	}
+
+	return refs, nil
+}
+
+// GitGetHookPath gets the path of the given hook (respecting core.hooksPath)
+func GitGetHookPath(name string) (string, error) {
+	output, err := gitOutput(nil, "rev-parse", "--git-path", "hooks/"+name)
 	if err != nil {
-		return "", fmt.Errorf("Failed to get absolute path of %s: %v", path, err)
+		return "", err
 	}
 
-	path, err = filepath.EvalSymlinks(path)
+	path, err := filepath.Abs(strings.TrimSpace(output))
 	if err != nil {
-		return "", fmt.Errorf("Failed to evaluate symlinks of %s: %v", path, err)
+		return "", fmt.Errorf("Failed to get absolute path of %s: %v", output, err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create hooks folder: %v", err)
 	}
 
 	return path, nil
@@ -82,6 +151,59 @@ func GitConfigGet(name string) (string, error) {
 	return strings.TrimSpace(output.String()), nil
 }
 
+// GitConfigGetAll executes 'git config [args...] --get-all <name>' and returns every value in order
+// (none if the setting isn't set)
+func GitConfigGetAll(name string, args ...string) []string {
+	output, err := gitOutput(nil, append(append([]string{"config"}, args...), "--get-all", name)...)
+	if err != nil || len(strings.TrimSpace(output)) < 1 {
+		return nil
+	}
+
+	return strings.Split(strings.TrimRight(output, "\n"), "\n")
+}
+
+// GitConfigOrigin gets the origin of a setting in the git config (e.g. "file:.git/config")
+func GitConfigOrigin(name string) (string, error) {
+	output, err := gitOutput(nil, "config", "--show-origin", "--get", name)
+	if err != nil {
+		return "", err
+	}
+
+	// The output is "<origin>\t<value>"
+	return strings.SplitN(strings.TrimSpace(output), "\t", 2)[0], nil
+}
+
+// GitConfigGetURLMatch executes 'git config --get-urlmatch <name> <url>' which also considers URL-scoped settings
+// like '<section>.<url>.<key>' (an empty string if the setting isn't set)
+func GitConfigGetURLMatch(name string, u string) string {
+	output, err := gitOutput(nil, "config", "--get-urlmatch", name, u)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(output)
+}
+
+// GitConfigGlobalGetRegexp executes 'git config --global --get-regexp <regexp>' and returns the names and values
+func GitConfigGlobalGetRegexp(regexp string) ([][2]string, error) {
+	output, err := gitOutput(nil, "config", "--global", "--get-regexp", regexp)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make([][2]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		pieces := strings.SplitN(line, " ", 2)
+		if len(pieces) < 2 {
+			pieces = append(pieces, "")
+		}
+
+		settings = append(settings, [2]string{pieces[0], pieces[1]})
+	}
+
+	return settings, nil
+}
+
 // GitConfigFileGet executes 'git config -f <file> --get <name>'
 func GitConfigFileGet(file string, name string) (string, error) {
 	output := new(bytes.Buffer)
@@ -103,6 +225,16 @@ func GitConfigFileGet(file string, name string) (string, error) {
 	return strings.TrimSpace(output.String()), nil
 }
 
+// GitConfigBlobGet executes 'git config --blob <blob> --get <name>'
+func GitConfigBlobGet(blob string, name string) (string, error) {
+	output, err := gitOutput(nil, "config", "--blob", blob, "--get", name)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(output), nil
+}
+
 // GitConfigSet executes 'git config <name> <value>'
 func GitConfigSet(name string, value string) error {
 	cmd := exec.Command("git", "config", name, value)
@@ -121,6 +253,12 @@ func GitConfigSet(name string, value string) error {
 	return nil
 }
 
+// GitConfigGlobalSet executes 'git config --global <name> <value>'
+func GitConfigGlobalSet(name string, value string) error {
+	_, err := gitOutput(nil, "config", "--global", name, value)
+	return err
+}
+
 // GitConfigFileSet executes 'git config -f <file> <name> <value>'
 func GitConfigFileSet(file string, name string, value string) error {
 	cmd := exec.Command("git", "config", "-f", file, name, value)
@@ -157,6 +295,12 @@ func GitConfigUnset(name string) error {
 	return nil
 }
 
+// GitConfigGlobalUnset executes 'git config --global --unset <name>'
+func GitConfigGlobalUnset(name string) error {
+	_, err := gitOutput(nil, "config", "--global", "--unset", name)
+	return err
+}
+
 // GitConfigFileUnset executes 'git config -f <file> --unset <name>'
 func GitConfigFileUnset(file string, name string) error {
 	cmd := exec.Command("git", "config", "-f", file, "--unset", name)
@@ -174,3 +318,47 @@ func GitConfigFileUnset(file string, name string) error {
 
 	return nil
 }
+
+// GitRun executes git in the given folder (the current one if empty) with additional environment variables.
+// The output is passed through so that the user sees the progress of git and Git LFS.
+func GitRun(dir string, env []string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Start()
+	if err == nil {
+		err = cmd.Wait()
+	}
+
+	if err != nil {
+		return fmt.Errorf("'git %s' failed with: %v", strings.Join(args, " "), err)
+	}
+
+	return nil
+}
+
+// gitOutput executes git with the given arguments and returns its output
+func gitOutput(stdin io.Reader, args ...string) (string, error) {
+	output := new(bytes.Buffer)
+
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdin = stdin
+	cmd.Stdout = output
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Start()
+	if err == nil {
+		err = cmd.Wait()
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("'git %s' failed with: %v", strings.Join(args, " "), err)
+	}
+
+	return output.String(), nil
+}
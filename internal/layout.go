@@ -0,0 +1,196 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultLayout is the layout used if nothing else is configured.
+	// It is the same layout Git LFS uses for .git/lfs/objects.
+	DefaultLayout = "lfs"
+
+	oidLength = 64
+)
+
+var (
+	oidRegexp         = regexp.MustCompile("^[0-9a-f]{64}$")
+	oidSearchRegexp   = regexp.MustCompile("[0-9a-f]{64}")
+	placeholderRegexp = regexp.MustCompile(`\{oid(?:\[(\d+):(\d+)\])?\}`)
+)
+
+// ValidOid checks whether the given string is a valid SHA-256 object id
+func ValidOid(oid string) bool {
+	return oidRegexp.MatchString(oid)
+}
+
+// Layout describes where the objects are stored on the remote.
+//
+// The following layouts are supported:
+//
+//	lfs               oid[0:2]/oid[2:4]/oid (the default)
+//	flat              oid
+//	fanout:<depth>    <depth> directory levels of two characters each followed by oid
+//	template:<path>   a path containing {oid} and optionally {oid[a:b]} placeholders
+//
+// Every layout can additionally be put below a fixed prefix path.
+type Layout struct {
+	Spec   string
+	Prefix string
+
+	template string
+}
+
+// ParseLayout parses the given layout specification
+func ParseLayout(spec string, prefix string) (*Layout, error) {
+	if len(spec) < 1 {
+		spec = DefaultLayout
+	}
+
+	var template string
+
+	switch {
+	case spec == "lfs":
+		template = fanoutTemplate(2)
+	case spec == "flat":
+		template = fanoutTemplate(0)
+	case strings.HasPrefix(spec, "fanout:"):
+		depth, err := strconv.Atoi(strings.TrimPrefix(spec, "fanout:"))
+		if err != nil || depth < 0 || depth > oidLength/2 {
+			return nil, fmt.Errorf("Invalid fanout depth in layout %q", spec)
+		}
+
+		template = fanoutTemplate(depth)
+	case strings.HasPrefix(spec, "template:"):
+		template = strings.Trim(strings.TrimPrefix(spec, "template:"), "/")
+
+		err := checkTemplate(template)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid template in layout %q: %v", spec, err)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown layout %q", spec)
+	}
+
+	return &Layout{Spec: spec, Prefix: strings.Trim(prefix, "/"), template: template}, nil
+}
+
+// LoadLayout loads the layout configured in lfs.webdav.layout and lfs.webdav.prefix
+func LoadLayout() (*Layout, error) {
+	return ParseLayout(LFSConfigGetOptional("lfs.webdav.layout"), LFSConfigGetOptional("lfs.webdav.prefix"))
+}
+
+func fanoutTemplate(depth int) string {
+	parts := make([]string, 0, depth+1)
+	for i := 0; i < depth; i++ {
+		parts = append(parts, fmt.Sprintf("{oid[%d:%d]}", i*2, i*2+2))
+	}
+
+	return strings.Join(append(parts, "{oid}"), "/")
+}
+
+func checkTemplate(template string) error {
+	// Every path must contain the full oid in its last segment,
+	// otherwise the objects can't be found again when walking the remote
+	if !strings.Contains(path.Base(template), "{oid}") {
+		return fmt.Errorf("the last path segment must contain {oid}")
+	}
+
+	for _, match := range placeholderRegexp.FindAllStringSubmatch(template, -1) {
+		if len(match[1]) < 1 {
+			continue
+		}
+
+		start, _ := strconv.Atoi(match[1])
+		end, _ := strconv.Atoi(match[2])
+		if start >= end || end > oidLength {
+			return fmt.Errorf("invalid placeholder %s", match[0])
+		}
+	}
+
+	return nil
+}
+
+// String returns the layout specification
+func (l *Layout) String() string {
+	if len(l.Prefix) > 0 {
+		return fmt.Sprintf("%s (prefix %q)", l.Spec, l.Prefix)
+	}
+
+	return l.Spec
+}
+
+// ObjectPath returns the remote path of the given object
+func (l *Layout) ObjectPath(oid string) string {
+	p := placeholderRegexp.ReplaceAllStringFunc(l.template, func(placeholder string) string {
+		match := placeholderRegexp.FindStringSubmatch(placeholder)
+		if len(match[1]) < 1 {
+			return oid
+		}
+
+		start, _ := strconv.Atoi(match[1])
+		end, _ := strconv.Atoi(match[2])
+		return oid[start:end]
+	})
+
+	return path.Join(l.Prefix, p)
+}
+
+// ObjectDir returns the remote directory that contains the given object
+func (l *Layout) ObjectDir(oid string) string {
+	dir := path.Dir(l.ObjectPath(oid))
+	if dir == "." {
+		return ""
+	}
+
+	return dir
+}
+
+// Root returns the deepest remote directory that contains all objects
+func (l *Layout) Root() string {
+	static := l.template
+	if i := strings.Index(static, "{"); i >= 0 {
+		static = static[:i]
+	}
+
+	if i := strings.LastIndex(static, "/"); i >= 0 {
+		static = static[:i]
+	} else {
+		static = ""
+	}
+
+	return path.Join(l.Prefix, static)
+}
+
+// Matches checks whether the given remote path is the path of an object in this layout
+func (l *Layout) Matches(p string) (string, bool) {
+	// The name might contain more than the oid (e.g. a file extension in a template)
+	oid := oidSearchRegexp.FindString(path.Base(p))
+	if len(oid) < 1 {
+		return "", false
+	}
+
+	return oid, strings.Trim(p, "/") == l.ObjectPath(oid)
+}
@@ -0,0 +1,110 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import "testing"
+
+const testOid = "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+
+func TestParseLayout(t *testing.T) {
+	tests := []struct {
+		spec   string
+		prefix string
+		path   string
+		dir    string
+		root   string
+	}{
+		{"", "", "4d/7a/" + testOid, "4d/7a", ""},
+		{"lfs", "", "4d/7a/" + testOid, "4d/7a", ""},
+		{"flat", "", testOid, "", ""},
+		{"flat", "/objects/", "objects/" + testOid, "objects", "objects"},
+		{"fanout:0", "", testOid, "", ""},
+		{"fanout:3", "lfs", "lfs/4d/7a/21/" + testOid, "lfs/4d/7a/21", "lfs"},
+		{"template:objects/{oid[0:3]}/{oid}.bin", "", "objects/4d7/" + testOid + ".bin", "objects/4d7", "objects"},
+		{"template:/{oid[62:64]}/{oid}/", "p", "p/93/" + testOid, "p/93", "p"},
+	}
+
+	for _, test := range tests {
+		layout, err := ParseLayout(test.spec, test.prefix)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", test.spec, err)
+			continue
+		}
+
+		if p := layout.ObjectPath(testOid); p != test.path {
+			t.Errorf("Expected path %q for %q but got %q", test.path, test.spec, p)
+		}
+
+		if dir := layout.ObjectDir(testOid); dir != test.dir {
+			t.Errorf("Expected folder %q for %q but got %q", test.dir, test.spec, dir)
+		}
+
+		if root := layout.Root(); root != test.root {
+			t.Errorf("Expected root %q for %q but got %q", test.root, test.spec, root)
+		}
+
+		if oid, ok := layout.Matches(test.path); !ok || oid != testOid {
+			t.Errorf("Layout %q doesn't match its own path %q", test.spec, test.path)
+		}
+	}
+}
+
+func TestParseLayoutInvalid(t *testing.T) {
+	specs := []string{
+		"unknown",
+		"fanout:",
+		"fanout:-1",
+		"fanout:33",
+		"template:{oid}/objects",
+		"template:{oid[0:2]}/file",
+		"template:{oid[2:2]}/{oid}",
+		"template:{oid[0:65]}/{oid}",
+	}
+
+	for _, spec := range specs {
+		_, err := ParseLayout(spec, "")
+		if err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestLayoutMatches(t *testing.T) {
+	layout, _ := ParseLayout("lfs", "lfs")
+
+	paths := []string{
+		testOid,
+		"lfs/" + testOid,
+		"lfs/00/7a/" + testOid,
+		"lfs/4d/7a/" + testOid + ".tmp",
+		"lfs/4d/7a/not-an-oid",
+		"other/4d/7a/" + testOid,
+	}
+
+	for _, p := range paths {
+		if _, ok := layout.Matches(p); ok {
+			t.Errorf("Path %q shouldn't match", p)
+		}
+	}
+
+	if _, ok := layout.Matches("/lfs/4d/7a/" + testOid + "/"); !ok {
+		t.Error("Slashes around the path should be ignored")
+	}
+}
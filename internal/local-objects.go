@@ -0,0 +1,130 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LocalObjectPath returns the path of an object in the local Git LFS storage (.git/lfs/objects)
+func LocalObjectPath(gitPath string, oid string) string {
+	return filepath.Join(gitPath, "lfs", "objects", oid[0:2], oid[2:4], oid)
+}
+
+// VerifyObject reads the object from the given reader and checks its size and hash
+func VerifyObject(reader io.Reader, oid string, size int64) error {
+	hash := sha256.New()
+
+	n, err := io.Copy(hash, io.LimitReader(reader, size+1))
+	if err != nil {
+		return err
+	}
+
+	if n != size {
+		return fmt.Errorf("expected size %v but got %v", size, n)
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != oid {
+		return fmt.Errorf("hash mismatch")
+	}
+
+	return nil
+}
+
+// VerifyLocalObject checks that the object exists in the local Git LFS storage and is not corrupt
+func VerifyLocalObject(gitPath string, oid string, size int64) error {
+	file, err := os.Open(LocalObjectPath(gitPath, oid))
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return VerifyObject(file, oid, size)
+}
+
+// StoreLocalObject writes an object into the local Git LFS storage. It is written to .git/lfs/tmp first
+// and only moved into place if its size and hash are correct.
+func StoreLocalObject(gitPath string, oid string, size int64, reader io.Reader) error {
+	tmpDir := filepath.Join(gitPath, "lfs", "tmp")
+	err := os.MkdirAll(tmpDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filepath.Join(tmpDir, fmt.Sprintf("%v.tmp", oid)))
+	if err != nil {
+		return err
+	}
+
+	// The tmp file is removed unless it has been moved into place
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+
+	err = VerifyObject(io.TeeReader(reader, file), oid, size)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	p := LocalObjectPath(gitPath, oid)
+	err = os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), p)
+}
+
+// LocalObjects lists all objects in the local Git LFS storage (the size is taken from the file)
+func LocalObjects(gitPath string) ([]*Pointer, error) {
+	root := filepath.Join(gitPath, "lfs", "objects")
+	objects := make([]*Pointer, 0)
+
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && p == root {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+
+		// Only files in the right place are objects (e.g. not incomplete downloads)
+		oid := info.Name()
+		if info.Mode().IsRegular() && ValidOid(oid) && p == LocalObjectPath(gitPath, oid) {
+			objects = append(objects, &Pointer{Oid: oid, Size: info.Size()})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,418 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The manifest is an append-only log in the "manifest" folder next to the objects. Every upload session adds
+// a segment listing the uploaded objects which is signed with the ed25519 key of the uploader:
+//
+//	git-lfs-webdav manifest v1
+//	signer <base64 public key>
+//	<oid> <size>
+//	...
+//	signature <base64 signature of everything above>
+//
+// Segments are named after their SHA-256 and never overwritten. The transfer agent combines the segments of a push
+// into one when it terminates (see ManifestSession). Only segments signed by one of the keys
+// in TrustedKeysFile are used, so objects swapped by anyone else with write access are detected.
+const (
+	manifestHeader = "git-lfs-webdav manifest v1"
+
+	// TrustedKeysFile lists the keys which are allowed to sign manifests ("ed25519 <base64 public key> <name>" per line)
+	TrustedKeysFile = ".lfstrustedkeys"
+
+	// manifestJobs is the number of segments which are downloaded at the same time
+	manifestJobs = 8
+)
+
+// TrustedKey is a public key which is allowed to sign manifest segments
+type TrustedKey struct {
+	Name string
+	Key  ed25519.PublicKey
+}
+
+// ManifestEntry is an object listed in a segment with a valid signature
+type ManifestEntry struct {
+	Oid    string
+	Size   int64
+	Signer string
+}
+
+// Manifest contains the objects listed in all trusted segments
+type Manifest struct {
+	Entries map[string]*ManifestEntry
+
+	// Segments which have been ignored because they are not signed by a trusted key
+	Untrusted []string
+}
+
+// LoadTrustedKeys reads the trusted keys of the repository (nil if there are none)
+func LoadTrustedKeys() ([]*TrustedKey, error) {
+	data, err := ReadRepoFile(TrustedKeysFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read %q: %v", TrustedKeysFile, err)
+	}
+
+	var keys []*TrustedKey
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < 1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 || fields[0] != "ed25519" {
+			return nil, fmt.Errorf("Invalid key in line %d of %q", i+1, TrustedKeysFile)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Invalid key in line %d of %q", i+1, TrustedKeysFile)
+		}
+
+		name := fields[1][:8]
+		if len(fields) > 2 {
+			name = strings.TrimSpace(fields[2])
+		}
+
+		keys = append(keys, &TrustedKey{Name: name, Key: ed25519.PublicKey(key)})
+	}
+
+	return keys, nil
+}
+
+// FindTrustedKey returns the trusted key matching the given public key or nil
+func FindTrustedKey(keys []*TrustedKey, key ed25519.PublicKey) *TrustedKey {
+	for _, k := range keys {
+		if bytes.Equal(k.Key, key) {
+			return k
+		}
+	}
+
+	return nil
+}
+
+// FormatTrustedKey returns the line of TrustedKeysFile for the given key
+func FormatTrustedKey(key ed25519.PublicKey, name string) string {
+	return fmt.Sprintf("ed25519 %s %s", base64.StdEncoding.EncodeToString(key), name)
+}
+
+// LoadSigningKey reads the private key configured using lfs.webdav.signingKey (nil if it is not configured)
+func LoadSigningKey() (ed25519.PrivateKey, error) {
+	keyPath, _ := GitConfigGet("lfs.webdav.signingKey")
+	if len(keyPath) < 1 {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read signing key %q: %v", keyPath, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("Signing key %q is not a PEM encoded private key", keyPath)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse signing key %q: %v", keyPath, err)
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Signing key %q is not an ed25519 key", keyPath)
+	}
+
+	return edKey, nil
+}
+
+// GenerateSigningKey creates a new private key file (existing files are never overwritten)
+func GenerateSigningKey(keyPath string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(keyPath), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create signing key %q: %v", keyPath, err)
+	}
+
+	defer file.Close()
+
+	err = pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write signing key %q: %v", keyPath, err)
+	}
+
+	return key, nil
+}
+
+// ManifestDir returns the remote folder which contains the manifest segments
+func (r *Remote) ManifestDir() string {
+	return path.Join(r.Layout.Prefix, "manifest")
+}
+
+// LoadManifest reads all manifest segments and keeps the entries of those signed by one of the trusted keys
+func (r *Remote) LoadManifest(trusted []*TrustedKey) (*Manifest, error) {
+	listing, err := r.ListDir(r.ManifestDir())
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(listing))
+	for p, info := range listing {
+		if !info.IsDir && strings.HasSuffix(p, ".manifest") {
+			paths = append(paths, p)
+		}
+	}
+
+	// Oids signed by several segments are attributed to the first one, so the order has to be stable
+	sort.Strings(paths)
+
+	// Every segment costs a request, so they are downloaded in parallel
+	data := make([][]byte, len(paths))
+	errs := make([]error, len(paths))
+	Parallel(len(paths), manifestJobs, func(i int) {
+		reader, err := r.ReadStream(paths[i])
+		if err != nil {
+			errs[i] = err
+			return
+		}
+
+		data[i], errs[i] = ioutil.ReadAll(reader)
+		reader.Close()
+	})
+
+	manifest := &Manifest{Entries: make(map[string]*ManifestEntry)}
+
+	for i, p := range paths {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("Failed to read manifest segment %q: %v", p, errs[i])
+		}
+
+		entries, err := parseManifestSegment(data[i], trusted)
+		if err != nil {
+			manifest.Untrusted = append(manifest.Untrusted, fmt.Sprintf("%s (%v)", path.Base(p), err))
+			continue
+		}
+
+		for _, e := range entries {
+			if _, ok := manifest.Entries[e.Oid]; !ok {
+				manifest.Entries[e.Oid] = e
+			}
+		}
+	}
+
+	return manifest, nil
+}
+
+func parseManifestSegment(data []byte, trusted []*TrustedKey) ([]*ManifestEntry, error) {
+	idx := bytes.LastIndex(data, []byte("\nsignature "))
+	if idx < 0 {
+		return nil, fmt.Errorf("not signed")
+	}
+
+	signed := data[:idx+1]
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data[idx+len("\nsignature "):])))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(signed))
+	if !scanner.Scan() || scanner.Text() != manifestHeader {
+		return nil, fmt.Errorf("unknown format")
+	}
+
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), "signer ") {
+		return nil, fmt.Errorf("no signer")
+	}
+
+	signerKey, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(scanner.Text(), "signer "))
+	if err != nil || len(signerKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid signer")
+	}
+
+	signer := FindTrustedKey(trusted, ed25519.PublicKey(signerKey))
+	if signer == nil {
+		return nil, fmt.Errorf("signer %s is not trusted", base64.StdEncoding.EncodeToString(signerKey))
+	}
+
+	if !ed25519.Verify(signer.Key, signed, signature) {
+		return nil, fmt.Errorf("invalid signature of %s", signer.Name)
+	}
+
+	entries := make([]*ManifestEntry, 0)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || !ValidOid(fields[0]) {
+			return nil, fmt.Errorf("corrupt entry")
+		}
+
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt entry")
+		}
+
+		entries = append(entries, &ManifestEntry{Oid: fields[0], Size: size, Signer: signer.Name})
+	}
+
+	return entries, scanner.Err()
+}
+
+// AppendManifest signs the given objects with the key and adds them to the manifest as a new segment
+func (r *Remote) AppendManifest(key ed25519.PrivateKey, objects []*Pointer) error {
+	_, err := r.writeManifestSegment(key, objects)
+	return err
+}
+
+// writeManifestSegment uploads a new segment and returns its path (empty if there are no objects)
+func (r *Remote) writeManifestSegment(key ed25519.PrivateKey, objects []*Pointer) (string, error) {
+	if len(objects) < 1 {
+		return "", nil
+	}
+
+	segment := signManifestSegment(key, objects)
+
+	sum := sha256.Sum256(segment)
+	p := path.Join(r.ManifestDir(), hex.EncodeToString(sum[:])+".manifest")
+
+	err := r.EnsureDir(r.ManifestDir())
+	if err != nil {
+		return "", err
+	}
+
+	// The name is derived from the contents, so if the segment exists already it is the same one
+	err = r.CreateStream(p, bytes.NewReader(segment), int64(len(segment)))
+	if err != nil && !HasStatus(err, http.StatusPreconditionFailed) {
+		return "", fmt.Errorf("Failed to write manifest segment %q: %v", p, err)
+	}
+
+	return p, nil
+}
+
+// ManifestSession collects the objects signed by the transfer agent. Git LFS only sends the next object after
+// the previous one has been reported as complete, so every object has to be signed in a segment of its own.
+// Close combines them into a single segment, which keeps the number of segments at one per push.
+type ManifestSession struct {
+	remote *Remote
+	key    ed25519.PrivateKey
+
+	objects  []*Pointer
+	segments []string
+}
+
+// NewManifestSession starts a session which signs objects with the key
+func (r *Remote) NewManifestSession(key ed25519.PrivateKey) *ManifestSession {
+	return &ManifestSession{remote: r, key: key}
+}
+
+// Add signs a single object and adds it to the manifest right away
+func (s *ManifestSession) Add(oid string, size int64) error {
+	o := &Pointer{Oid: oid, Size: size}
+
+	p, err := s.remote.writeManifestSegment(s.key, []*Pointer{o})
+	if err != nil {
+		return err
+	}
+
+	s.objects = append(s.objects, o)
+	s.segments = append(s.segments, p)
+
+	return nil
+}
+
+// Close replaces the segments of the session by a single one. The combined segment is written before anything
+// is deleted, so the objects stay signed if this fails halfway.
+func (s *ManifestSession) Close() error {
+	if len(s.segments) < 2 {
+		return nil
+	}
+
+	combined, err := s.remote.writeManifestSegment(s.key, s.objects)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range s.segments {
+		if p == combined {
+			continue
+		}
+
+		err = s.remote.Delete(p)
+		if err != nil {
+			return fmt.Errorf("Failed to delete manifest segment %q: %v", p, err)
+		}
+	}
+
+	s.objects = nil
+	s.segments = nil
+
+	return nil
+}
+
+// signManifestSegment creates a segment listing the given objects signed with the key
+func signManifestSegment(key ed25519.PrivateKey, objects []*Pointer) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(manifestHeader + "\n")
+	fmt.Fprintf(buf, "signer %s\n", base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+
+	for _, o := range objects {
+		fmt.Fprintf(buf, "%s %d\n", o.Oid, o.Size)
+	}
+
+	fmt.Fprintf(buf, "signature %s\n", base64.StdEncoding.EncodeToString(ed25519.Sign(key, buf.Bytes())))
+
+	return buf.Bytes()
+}
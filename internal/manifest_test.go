@@ -0,0 +1,100 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestManifestSegment(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oid1, size1 := testObject("first")
+	oid2, size2 := testObject("second")
+
+	segment := signManifestSegment(private, []*Pointer{{Oid: oid1, Size: size1}, {Oid: oid2, Size: size2}})
+
+	trusted := []*TrustedKey{{Name: "alice", Key: public}}
+	entries, err := parseManifestSegment(segment, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries but got %d", len(entries))
+	}
+
+	if entries[0].Oid != oid1 || entries[0].Size != size1 || entries[1].Oid != oid2 || entries[1].Size != size2 {
+		t.Errorf("Unexpected entries %+v %+v", entries[0], entries[1])
+	}
+
+	if entries[0].Signer != "alice" {
+		t.Errorf("Expected signer alice but got %q", entries[0].Signer)
+	}
+}
+
+func TestManifestSegmentRejected(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	oid1, size1 := testObject("first")
+	oid2, _ := testObject("second")
+
+	segment := signManifestSegment(private, []*Pointer{{Oid: oid1, Size: size1}})
+	trusted := []*TrustedKey{{Name: "alice", Key: public}}
+
+	// Signed by a key which isn't trusted
+	_, err := parseManifestSegment(segment, []*TrustedKey{{Name: "bob", Key: other}})
+	if err == nil || !strings.Contains(err.Error(), "not trusted") {
+		t.Errorf("Expected an untrusted signer but got %v", err)
+	}
+
+	// Swapped object
+	_, err = parseManifestSegment(bytes.Replace(segment, []byte(oid1), []byte(oid2), 1), trusted)
+	if err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Errorf("Expected an invalid signature for a swapped oid but got %v", err)
+	}
+
+	// Changed size
+	_, err = parseManifestSegment(bytes.Replace(segment, []byte(" 5\n"), []byte(" 6\n"), 1), trusted)
+	if err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Errorf("Expected an invalid signature for a changed size but got %v", err)
+	}
+
+	// Entries appended after the signature
+	appended := append(append([]byte{}, segment...), []byte(oid2+" 6\n")...)
+	_, err = parseManifestSegment(appended, trusted)
+	if err == nil {
+		t.Error("Expected an error for entries after the signature")
+	}
+
+	// Missing signature
+	_, err = parseManifestSegment(segment[:bytes.LastIndex(segment, []byte("signature "))], trusted)
+	if err == nil {
+		t.Error("Expected an error for a segment without signature")
+	}
+}
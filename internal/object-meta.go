@@ -0,0 +1,149 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Uploaded objects are annotated with dead properties in this namespace (sha256, uploader, uploaded, version and etag)
+// so that their integrity can be checked with a PROPFIND instead of downloading them.
+// Servers which don't support dead properties simply never return them.
+const propNamespace = "https://github.com/mpotthoff/git-lfs-webdav"
+
+// unsupportedProperties are the status codes of servers which don't support dead properties
+// (or not on this path). Other errors are temporary and fail the upload.
+var unsupportedProperties = map[int]bool{
+	http.StatusForbidden:           true,
+	http.StatusMethodNotAllowed:    true,
+	http.StatusConflict:            true,
+	http.StatusUnprocessableEntity: true,
+	http.StatusNotImplemented:      true,
+}
+
+// ObjectMeta is the metadata stored with an uploaded object
+type ObjectMeta struct {
+	SHA256   string
+	Uploader string
+	Uploaded time.Time
+	Version  string
+
+	// UploadETag is the ETag the server reported for the upload (empty if it didn't send one)
+	UploadETag string
+}
+
+// Check compares the metadata with the current state of the remote file and returns a description of the problem
+// or an empty string. A changed ETag only hints at a modification, the content has to be verified in that case.
+func (m *ObjectMeta) Check(oid string, info *RemoteFile) (problem string, modified bool) {
+	if m.SHA256 != oid {
+		return fmt.Sprintf("checksum property is %s", m.SHA256), false
+	}
+
+	if len(m.UploadETag) > 0 && len(info.ETag) > 0 && m.UploadETag != info.ETag {
+		return "modified after the upload", true
+	}
+
+	return "", false
+}
+
+// CreateObject uploads a new loose object (see CreateStream) and annotates it with its metadata
+func (r *Remote) CreateObject(oid string, reader io.Reader, size int64) error {
+	p := r.Layout.ObjectPath(oid)
+
+	etag, err := r.createStream(p, reader, size)
+	if err != nil {
+		return err
+	}
+
+	return r.annotate(p, oid, etag)
+}
+
+// ReplaceObject overwrites a loose object (see ReplaceStream) and annotates it with its metadata
+func (r *Remote) ReplaceObject(oid string, reader io.Reader, size int64, etag string) error {
+	p := r.Layout.ObjectPath(oid)
+
+	etag, err := r.replaceStream(p, reader, size, etag)
+	if err != nil {
+		return err
+	}
+
+	return r.annotate(p, oid, etag)
+}
+
+// annotate stores the metadata of an uploaded object. Servers which reject dead properties (see
+// unsupportedProperties) are remembered and the properties are not sent again, other errors fail the upload.
+func (r *Remote) annotate(p string, oid string, etag string) error {
+	r.mu.Lock()
+	if !r.Properties {
+		r.mu.Unlock()
+		return nil
+	}
+
+	if len(r.uploader) < 1 {
+		name, _ := GitConfigGet("user.name")
+		email, _ := GitConfigGet("user.email")
+		r.uploader = fmt.Sprintf("%s <%s>", name, email)
+	}
+
+	meta := &ObjectMeta{SHA256: oid, Uploader: r.uploader, Uploaded: time.Now().UTC(), Version: Version, UploadETag: etag}
+	r.mu.Unlock()
+
+	props := map[string]string{
+		"sha256":   meta.SHA256,
+		"uploader": meta.Uploader,
+		"uploaded": meta.Uploaded.Format(time.RFC3339),
+		"version":  meta.Version,
+	}
+	if len(etag) > 0 {
+		props["etag"] = etag
+	}
+
+	err := r.client.Proppatch(r.ctx, p, props)
+	if err != nil && r.checkAuth(err) {
+		// If the credentials were changed retry the call
+		err = r.client.Proppatch(r.ctx, p, props)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		var serr *StatusError
+		if errors.As(err, &serr) && unsupportedProperties[serr.StatusCode] {
+			r.Properties = false
+			return nil
+		}
+
+		// Not wrapped, a failed precondition of the PROPPATCH mustn't look like one of the upload
+		return fmt.Errorf("Failed to store the metadata of %q: %v", "/"+p, err)
+	}
+
+	if listing, ok := r.listings[parentDir(p)]; ok {
+		if info, ok := listing[p]; ok {
+			info.Meta = meta
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Small objects can be combined into packs to avoid storing thousands of tiny files on the server.
+// A pack consists of "<name>.pack" (the objects concatenated) and "<name>.idx" (one "<oid> <offset> <size>"
+// line per object). The index is always written after and deleted before the pack, so an index only
+// exists for complete packs. The name of a pack is the SHA-256 of its index.
+const (
+	packIndexHeader = "git-lfs-webdav pack v1"
+
+	// DefaultPackSize is the size at which a pack is uploaded and a new one is started
+	DefaultPackSize = 64 << 20
+)
+
+// PackEntry is the location of an object inside a pack
+type PackEntry struct {
+	Pack   string
+	Oid    string
+	Offset int64
+	Size   int64
+}
+
+// PackDir returns the remote folder which contains the packs
+func (r *Remote) PackDir() string {
+	return path.Join(r.Layout.Prefix, "packs")
+}
+
+func (r *Remote) packPath(name string, ext string) string {
+	return path.Join(r.PackDir(), name+ext)
+}
+
+// loadPacks reads all pack indexes once per session (r.packsMu has to be held)
+func (r *Remote) loadPacks() error {
+	if r.packs != nil {
+		return nil
+	}
+
+	listing, err := r.ListDir(r.PackDir())
+	if err != nil {
+		return err
+	}
+
+	packs := make(map[string]*PackEntry)
+	for p := range listing {
+		if !strings.HasSuffix(p, ".idx") {
+			continue
+		}
+
+		entries, err := r.readPackIndex(strings.TrimSuffix(path.Base(p), ".idx"))
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			packs[e.Oid] = e
+		}
+	}
+
+	r.packs = packs
+	return nil
+}
+
+func (r *Remote) readPackIndex(name string) ([]*PackEntry, error) {
+	p := r.packPath(name, ".idx")
+
+	reader, err := r.ReadStream(p)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read pack index %q: %v", p, err)
+	}
+
+	defer reader.Close()
+
+	return parsePackIndex(p, name, reader)
+}
+
+// parsePackIndex reads the entries of the index p of the pack name
+func parsePackIndex(p string, name string, reader io.Reader) ([]*PackEntry, error) {
+	entries := make([]*PackEntry, 0)
+
+	scanner := bufio.NewScanner(reader)
+	if !scanner.Scan() || scanner.Text() != packIndexHeader {
+		return nil, fmt.Errorf("Pack index %q has an unknown format", p)
+	}
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || !ValidOid(fields[0]) {
+			return nil, fmt.Errorf("Pack index %q is corrupt", p)
+		}
+
+		offset, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Pack index %q is corrupt", p)
+		}
+
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Pack index %q is corrupt", p)
+		}
+
+		entries = append(entries, &PackEntry{Pack: name, Oid: fields[0], Offset: offset, Size: size})
+	}
+
+	return entries, scanner.Err()
+}
+
+// FindPacked returns the location of the given object if it is stored in a pack
+func (r *Remote) FindPacked(oid string) (*PackEntry, error) {
+	r.packsMu.Lock()
+	defer r.packsMu.Unlock()
+
+	err := r.loadPacks()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.packs[oid], nil
+}
+
+// PackEntries returns all objects stored in packs grouped by pack
+func (r *Remote) PackEntries() (map[string][]*PackEntry, error) {
+	r.packsMu.Lock()
+	defer r.packsMu.Unlock()
+
+	err := r.loadPacks()
+	if err != nil {
+		return nil, err
+	}
+
+	packs := make(map[string][]*PackEntry)
+
+	// Packs which only contain objects that are also in other packs have to be included as well
+	listing, _ := r.ListDir(r.PackDir())
+	for p := range listing {
+		if strings.HasSuffix(p, ".idx") {
+			packs[strings.TrimSuffix(path.Base(p), ".idx")] = nil
+		}
+	}
+
+	for _, e := range r.packs {
+		packs[e.Pack] = append(packs[e.Pack], e)
+	}
+
+	return packs, nil
+}
+
+// ReadPacked opens an object inside a pack using a ranged GET
+func (r *Remote) ReadPacked(e *PackEntry) (io.ReadCloser, error) {
+	p := r.packPath(e.Pack, ".pack")
+
+	header := make(http.Header)
+	if r.Ranges {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", e.Offset, e.Offset+e.Size-1))
+	}
+
+	resp, err := r.client.Get(r.ctx, p, header)
+	if err != nil && r.checkAuth(err) {
+		// If the credentials were changed retry the call
+		resp, err = r.client.Get(r.ctx, p, header)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Some servers ignore the range and send the whole pack (like without Ranges)
+	if resp.StatusCode == http.StatusOK {
+		_, err = io.CopyN(ioutil.Discard, resp.Body, e.Offset)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+
+	return &readCloser{NewRateLimitedReader(io.LimitReader(resp.Body, e.Size), r.DownloadLimit), resp.Body}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// PackWriter builds a pack in a local temporary file
+type PackWriter struct {
+	file    *os.File
+	entries []*PackEntry
+	oids    map[string]bool
+	size    int64
+}
+
+// NewPackWriter creates a new pack in the given local folder
+func NewPackWriter(dir string) (*PackWriter, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := ioutil.TempFile(dir, "pack-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temporary pack: %v", err)
+	}
+
+	return &PackWriter{file: file, oids: make(map[string]bool)}, nil
+}
+
+// Add copies an object into the pack and verifies its size and hash
+func (w *PackWriter) Add(oid string, size int64, reader io.Reader) error {
+	if w.oids[oid] {
+		return nil
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w.file, hash), io.LimitReader(reader, size+1))
+	if err == nil && n != size {
+		err = fmt.Errorf("expected size %v but got %v", size, n)
+	}
+	if err == nil && hex.EncodeToString(hash.Sum(nil)) != oid {
+		err = fmt.Errorf("hash mismatch")
+	}
+	if err != nil {
+		// Cut off whatever has been written
+		w.file.Truncate(w.size)
+		w.file.Seek(w.size, io.SeekStart)
+		return fmt.Errorf("Failed to add object %s to the pack: %v", oid, err)
+	}
+
+	w.entries = append(w.entries, &PackEntry{Oid: oid, Offset: w.size, Size: size})
+	w.oids[oid] = true
+	w.size += size
+
+	return nil
+}
+
+// Contains checks whether the object has been added to the pack
+func (w *PackWriter) Contains(oid string) bool {
+	return w.oids[oid]
+}
+
+// Entries returns the objects in the pack
+func (w *PackWriter) Entries() []*PackEntry {
+	return w.entries
+}
+
+// Size returns the current size of the pack
+func (w *PackWriter) Size() int64 {
+	return w.size
+}
+
+// Object returns a reader for an object which has been added to the pack
+func (w *PackWriter) Object(e *PackEntry) io.Reader {
+	return io.NewSectionReader(w.file, e.Offset, e.Size)
+}
+
+// Remove deletes the temporary pack
+func (w *PackWriter) Remove() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+func (w *PackWriter) index() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(packIndexHeader + "\n")
+
+	for _, e := range w.entries {
+		fmt.Fprintf(buf, "%s %d %d\n", e.Oid, e.Offset, e.Size)
+	}
+
+	return buf.Bytes()
+}
+
+// UploadPack uploads the pack and its index and returns the name of the pack
+func (r *Remote) UploadPack(w *PackWriter) (string, error) {
+	index := w.index()
+	sum := sha256.Sum256(index)
+	name := hex.EncodeToString(sum[:])
+
+	err := r.EnsureDir(r.PackDir())
+	if err != nil {
+		return "", err
+	}
+
+	_, err = w.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	// The name is derived from the contents, so if a pack exists already it is the same one
+	err = r.CreateStream(r.packPath(name, ".pack"), w.file, w.size)
+	if err != nil && !HasStatus(err, http.StatusPreconditionFailed) {
+		return "", err
+	}
+
+	err = r.CreateStream(r.packPath(name, ".idx"), bytes.NewReader(index), int64(len(index)))
+	if err != nil && !HasStatus(err, http.StatusPreconditionFailed) {
+		return "", err
+	}
+
+	r.packsMu.Lock()
+	defer r.packsMu.Unlock()
+
+	if r.packs != nil {
+		for _, e := range w.entries {
+			r.packs[e.Oid] = &PackEntry{Pack: name, Oid: e.Oid, Offset: e.Offset, Size: e.Size}
+		}
+	}
+
+	return name, nil
+}
+
+// DeletePack removes a pack from the server (the index first so that the pack is never used incomplete)
+func (r *Remote) DeletePack(name string) error {
+	err := r.Delete(r.packPath(name, ".idx"))
+	if err != nil {
+		return err
+	}
+
+	return r.Delete(r.packPath(name, ".pack"))
+}
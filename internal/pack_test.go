@@ -0,0 +1,156 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func testObject(content string) (string, int64) {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:]), int64(len(content))
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pack-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	writer, err := NewPackWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer writer.Remove()
+
+	contents := []string{"first object", "", "the third object is a bit longer than the others"}
+	for _, content := range contents {
+		oid, size := testObject(content)
+		err = writer.Add(oid, size, strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Adding an object twice doesn't store it again
+	oid, size := testObject(contents[0])
+	err = writer.Add(oid, size, strings.NewReader(contents[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(writer.Entries()) != len(contents) {
+		t.Fatalf("Expected %d entries but got %d", len(contents), len(writer.Entries()))
+	}
+
+	entries, err := parsePackIndex("packs/test.idx", "test", bytes.NewReader(writer.index()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != len(contents) {
+		t.Fatalf("Expected %d index entries but got %d", len(contents), len(entries))
+	}
+
+	for i, e := range entries {
+		oid, size := testObject(contents[i])
+		if e.Pack != "test" || e.Oid != oid || e.Size != size {
+			t.Errorf("Unexpected entry %+v for %q", e, contents[i])
+		}
+
+		data, err := ioutil.ReadAll(writer.Object(e))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != contents[i] {
+			t.Errorf("Expected %q but read %q", contents[i], data)
+		}
+	}
+}
+
+func TestPackAddInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pack-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	writer, err := NewPackWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer writer.Remove()
+
+	oid, size := testObject("content")
+
+	if writer.Add(oid, size, strings.NewReader("CONTENT")) == nil {
+		t.Error("Expected a hash mismatch")
+	}
+
+	if writer.Add(oid, size, strings.NewReader("content and more")) == nil {
+		t.Error("Expected a size mismatch")
+	}
+
+	if writer.Size() != 0 || len(writer.Entries()) != 0 || writer.Contains(oid) {
+		t.Errorf("Invalid objects have been added to the pack")
+	}
+
+	// The pack is still usable afterwards
+	err = writer.Add(oid, size, strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadAll(writer.Object(writer.Entries()[0]))
+	if string(data) != "content" {
+		t.Errorf("Expected %q but read %q", "content", data)
+	}
+}
+
+func TestParsePackIndexCorrupt(t *testing.T) {
+	oid, _ := testObject("content")
+
+	indexes := []string{
+		"",
+		"unknown header\n",
+		packIndexHeader + "\n" + oid + " 0\n",
+		packIndexHeader + "\nnot-an-oid 0 7\n",
+		packIndexHeader + "\n" + oid + " x 7\n",
+	}
+
+	for _, index := range indexes {
+		_, err := parsePackIndex("packs/test.idx", "test", strings.NewReader(index))
+		if err == nil {
+			t.Errorf("Expected an error for index %q", index)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"strconv"
+	"sync"
+)
+
+// DefaultConcurrency is the number of parallel transfers if lfs.concurrenttransfers isn't set (like in Git LFS)
+const DefaultConcurrency = 8
+
+// Concurrency returns the configured number of parallel transfers (lfs.concurrenttransfers)
+func Concurrency() int {
+	n, err := strconv.Atoi(LFSConfigGetOptional("lfs.concurrenttransfers"))
+	if err != nil || n < 1 {
+		return DefaultConcurrency
+	}
+
+	return n
+}
+
+// Parallel calls fn for every index from 0 to count-1 using the given number of goroutines
+func Parallel(count int, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,156 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Pointers larger than this can't be valid (same limit as in Git LFS)
+const maxPointerSize = 1024
+
+// Pointer is a Git LFS pointer file found in the repository
+type Pointer struct {
+	Oid  string
+	Size int64
+}
+
+// ParsePointer parses the contents of a Git LFS pointer file
+func ParsePointer(data []byte) (*Pointer, bool) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 3 || !strings.HasPrefix(lines[0], "version https://git-lfs.github.com/spec/v1") && !strings.HasPrefix(lines[0], "version https://hawser.github.com/spec/v1") {
+		return nil, false
+	}
+
+	p := &Pointer{Size: -1}
+	for _, line := range lines[1:] {
+		pieces := strings.SplitN(line, " ", 2)
+		if len(pieces) < 2 {
+			return nil, false
+		}
+
+		switch pieces[0] {
+		case "oid":
+			p.Oid = strings.TrimPrefix(pieces[1], "sha256:")
+		case "size":
+			size, err := strconv.ParseInt(pieces[1], 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			p.Size = size
+		}
+	}
+
+	if !ValidOid(p.Oid) || p.Size < 0 {
+		return nil, false
+	}
+
+	return p, true
+}
+
+// GitLFSPointers lists the Git LFS pointers in all commits selected by the given 'git rev-list' arguments
+// (e.g. "--all" or "main" "--not" "--remotes=origin"). Every oid is only returned once.
+func GitLFSPointers(revListArgs ...string) ([]*Pointer, error) {
+	revs, err := gitOutput(nil, append([]string{"rev-list", "--objects"}, revListArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only keep the object names
+	input := new(bytes.Buffer)
+	for _, line := range strings.Split(revs, "\n") {
+		if len(line) >= 40 {
+			input.WriteString(strings.SplitN(line, " ", 2)[0])
+			input.WriteString("\n")
+		}
+	}
+
+	// Find the blobs small enough to be pointers
+	objects, err := gitOutput(input, "cat-file", "--batch-check=%(objectname) %(objecttype) %(objectsize)")
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := new(bytes.Buffer)
+	for _, line := range strings.Split(objects, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[1] != "blob" {
+			continue
+		}
+
+		size, err := strconv.Atoi(fields[2])
+		if err == nil && size < maxPointerSize {
+			candidates.WriteString(fields[0])
+			candidates.WriteString("\n")
+		}
+	}
+
+	return readPointers(candidates)
+}
+
+func readPointers(candidates io.Reader) ([]*Pointer, error) {
+	output, err := gitOutput(candidates, "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+
+	pointers := make([]*Pointer, 0)
+	seen := make(map[string]bool)
+
+	reader := bufio.NewReader(strings.NewReader(output))
+	for {
+		header, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Every object is written as "<name> <type> <size>\n<contents>\n"
+		fields := strings.Fields(header)
+		if len(fields) < 3 {
+			continue
+		}
+
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("Unexpected output of 'git cat-file --batch': %q", header)
+		}
+
+		data := make([]byte, size+1)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, err
+		}
+
+		if p, ok := ParsePointer(data[:size]); ok && !seen[p.Oid] {
+			seen[p.Oid] = true
+			pointers = append(pointers, p)
+		}
+	}
+
+	return pointers, nil
+}
@@ -0,0 +1,169 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+)
+
+// Capabilities are the optional features of a server detected by Probe.
+// 'init' records them in .lfsconfig (lfs.webdav.ranges and lfs.webdav.deadProperties) for the transfer agent.
+type Capabilities struct {
+	// Ranges is true if the server answers Range requests with partial content (used to read packed objects)
+	Ranges bool
+
+	// DeadProperties is true if the server stores custom properties set with PROPPATCH (used for the object metadata)
+	DeadProperties bool
+}
+
+// Probe checks that the server supports everything the transfer agent needs (PROPFIND, MKCOL, PUT, GET, MOVE and DELETE)
+// using a temporary folder below the prefix and detects the optional capabilities
+func (r *Remote) Probe() (*Capabilities, error) {
+	_, err := r.Stat("")
+	if IsNotFound(err) {
+		return nil, fmt.Errorf("The folder %q doesn't exist on the server", r.URL.String())
+	} else if HasStatus(err, http.StatusMethodNotAllowed) || HasStatus(err, http.StatusNotImplemented) {
+		return nil, fmt.Errorf("The server doesn't support WebDAV (PROPFIND): %w", err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	random := make([]byte, 8)
+	_, err = rand.Read(random)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := path.Join(r.Layout.Prefix, ".probe-"+hex.EncodeToString(random))
+	content := []byte("git-lfs-webdav probe " + hex.EncodeToString(random) + "\n")
+
+	err = r.EnsureDir(dir)
+	if err != nil {
+		return nil, probeError("MKCOL", err)
+	}
+
+	cleanup := true
+	defer func() {
+		// Don't leave the probe behind if one of the checks failed
+		if cleanup {
+			r.Delete(dir)
+		}
+	}()
+
+	p := path.Join(dir, "probe")
+	err = r.CreateStream(p, bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, probeError("PUT", err)
+	}
+
+	reader, err := r.ReadStream(p)
+	if err != nil {
+		return nil, probeError("GET", err)
+	}
+
+	read, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(read, content) {
+		return nil, fmt.Errorf("The server returned a different content for %q than uploaded", "/"+p)
+	}
+
+	caps := &Capabilities{}
+
+	caps.Ranges, err = r.probeRanges(p, content)
+	if err != nil {
+		return nil, err
+	}
+
+	caps.DeadProperties = r.probeDeadProperties(p)
+
+	moved := path.Join(dir, "moved")
+	err = r.Move(p, moved)
+	if err != nil {
+		return nil, probeError("MOVE", err)
+	}
+
+	err = r.client.Delete(r.ctx, moved)
+	if err == nil {
+		err = r.client.Delete(r.ctx, dir)
+	}
+	if err != nil {
+		return nil, probeError("DELETE", err)
+	}
+
+	cleanup = false
+
+	return caps, nil
+}
+
+// probeError only blames the server for missing support if it rejected the method. Other errors like 401 Unauthorized
+// or 403 Forbidden are wrapped, so callers can still check their status.
+func probeError(method string, err error) error {
+	if HasStatus(err, http.StatusMethodNotAllowed) || HasStatus(err, http.StatusNotImplemented) {
+		return fmt.Errorf("The server doesn't support %s: %w", method, err)
+	}
+
+	return fmt.Errorf("The %s request of the server check failed: %w", method, err)
+}
+
+// probeRanges checks whether a Range request returns exactly the requested part
+func (r *Remote) probeRanges(p string, content []byte) (bool, error) {
+	header := make(http.Header)
+	header.Set("Range", "bytes=4-11")
+
+	resp, err := r.client.Get(r.ctx, p, header)
+	if err != nil {
+		return false, probeError("GET", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return false, nil
+	}
+
+	read, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(read, content[4:12]), nil
+}
+
+// probeDeadProperties checks whether a property set with PROPPATCH is returned by PROPFIND afterwards
+// (some servers accept the request but drop the property)
+func (r *Remote) probeDeadProperties(p string) bool {
+	err := r.client.Proppatch(r.ctx, p, map[string]string{"sha256": "probe"})
+	if err != nil {
+		return false
+	}
+
+	meta, err := r.client.Properties(r.ctx, p)
+	if err != nil {
+		return false
+	}
+
+	return meta != nil && meta.SHA256 == "probe"
+}
@@ -0,0 +1,57 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProbeError(t *testing.T) {
+	tests := []struct {
+		status      int
+		unsupported bool
+	}{
+		{http.StatusMethodNotAllowed, true},
+		{http.StatusNotImplemented, true},
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+		{http.StatusInsufficientStorage, false},
+	}
+
+	for _, test := range tests {
+		err := probeError("PUT", fmt.Errorf("Failed: %w", &StatusError{"PUT", "probe", test.status, http.StatusText(test.status)}))
+
+		if !HasStatus(err, test.status) {
+			t.Errorf("Expected status %d to be kept but got %v", test.status, err)
+		}
+		if strings.Contains(err.Error(), "doesn't support") != test.unsupported {
+			t.Errorf("Unexpected message for status %d: %v", test.status, err)
+		}
+	}
+
+	err := probeError("MKCOL", context.Canceled)
+	if strings.Contains(err.Error(), "doesn't support") {
+		t.Errorf("Unexpected message for a cancellation: %v", err)
+	}
+}
@@ -21,152 +21,187 @@ package internal
 
 import (
 	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/url"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
-
-	"github.com/studio-b12/gowebdav"
+	"syscall"
+	"time"
 )
 
 var (
 	gitPath string
-	baseURL *url.URL
-	creds   Creds
-	client  *gowebdav.Client
-)
 
-func createClient() {
-	var username string
-	var password string
+	// The primary remote (lfs.url) stores the packs and the manifest, the router chooses the remote of every object
+	remote *Remote
+	router *Router
 
-	if creds != nil {
-		username = creds["username"]
-		password = creds["password"]
-	}
+	// Corrupt remote objects are only overwritten if this has been requested explicitly
+	repair bool
 
-	client = gowebdav.NewClient(baseURL.String(), username, password)
-}
+	// Uploaded objects are added to the manifest if a signing key is configured. Downloads are verified
+	// against the manifest if the repository lists trusted keys.
+	trustedKeys []*TrustedKey
+	signingKey  ed25519.PrivateKey
+	manifest    *Manifest
 
-func checkAuth(err error) bool {
-	// Check whether the error is of type PathError
-	if perr, ok := err.(*os.PathError); ok {
-		// Check whether it is an "Authorize" error and we don't already have credentials
-		if perr.Op == "Authorize" && creds == nil {
-			// Then ask the git credential manager
-
-			creds = make(Creds)
-			creds["url"] = baseURL.String()
-
-			creds, _ = GitCredentialFill(creds)
-			if creds != nil {
-				// If we got new credentials recreate the client
-				createClient()
-				return true
-			}
-		}
-	}
+	// Combines the manifest segments of the uploads when the agent terminates
+	signingSession *ManifestSession
 
-	return false
-}
+	// Cancelled on SIGINT/SIGTERM to abort the requests in flight
+	transferCtx context.Context
+)
 
-func processInit(operation string, remote string, concurrent bool, concurrentTransfer int, writer *bufio.Writer) error {
-	var (
-		err  error
-		err2 error
-	)
+func processInit(operation string, remoteName string, concurrent bool, concurrentTransfer int, writer *bufio.Writer) error {
+	var err error
 
 	gitPath, err = GitGetPath()
 	if err != nil {
 		return SendResponse(&InitResponse{&TransferError{1, fmt.Sprintf("Failed to get '.git' path: %v", err)}}, writer)
 	}
 
-	// First try to get the LFS URL from .git/config
-	lfsURL, err := GitConfigGet("lfs.url")
+	lfsURL, err := LFSConfigGet("lfs.url")
+	if err != nil {
+		return SendResponse(&InitResponse{&TransferError{2, fmt.Sprintf("Failed to get LFS URL: %v", err)}}, writer)
+	}
+
 	if len(lfsURL) < 1 {
-		// Next try .lfsconfig
-		lfsURL, err2 = GitConfigFileGet(".lfsconfig", "lfs.url")
-		if len(lfsURL) < 1 {
-			// Otherwise return an error
-			if err != nil || err2 != nil {
-				return SendResponse(&InitResponse{&TransferError{2, fmt.Sprintf("Failed to get LFS URL: %v\n%v", err, err2)}}, writer)
-			}
+		return SendResponse(&InitResponse{&TransferError{3, "Git LFS URL not configured!"}}, writer)
+	}
 
-			return SendResponse(&InitResponse{&TransferError{3, "Git LFS URL not configured!"}}, writer)
-		}
+	remote, err = NewRemote(lfsURL)
+	if err != nil {
+		return SendResponse(&InitResponse{&TransferError{4, err.Error()}}, writer)
 	}
 
-	baseURL, err = url.Parse(lfsURL)
+	routes, err := LoadRoutes()
+	if err == nil {
+		router, err = NewRouter(remote, routes, LoadShards())
+	}
 	if err != nil {
-		return SendResponse(&InitResponse{&TransferError{4, fmt.Sprintf("Failed to parse LFS URL %q: %v", lfsURL, err)}}, writer)
+		return SendResponse(&InitResponse{&TransferError{36, err.Error()}}, writer)
 	}
 
-	// Rewrite the URL back from webdav/webdavs to http/https
-	// This was done in cmd/init
-	if baseURL.Scheme == "webdav" {
-		baseURL.Scheme = "http"
-	} else if baseURL.Scheme == "webdavs" {
-		baseURL.Scheme = "https"
+	router.SetContext(transferCtx)
+
+	// Git LFS starts up to concurrenttransfers agents, so each one gets its share of the bandwidth limits
+	if concurrent {
+		remote.UploadLimit.Split(concurrentTransfer)
+		remote.DownloadLimit.Split(concurrentTransfer)
 	}
 
-	// Use any credentials passed in the URL
-	if baseURL.User != nil {
-		username := baseURL.User.Username()
-		password, passwordSet := baseURL.User.Password()
-		baseURL.User = nil
+	trustedKeys, err = LoadTrustedKeys()
+	if err == nil && operation == "upload" {
+		signingKey, err = LoadSigningKey()
+	}
+	if err != nil {
+		return SendResponse(&InitResponse{&TransferError{31, err.Error()}}, writer)
+	}
 
-		creds = make(Creds)
-		creds["username"] = username
-		if passwordSet {
-			creds["password"] = password
-		}
-	} else {
-		creds = nil
+	// Objects pushed without a trusted signature couldn't be downloaded by anyone
+	if operation == "upload" && trustedKeys != nil && (signingKey == nil || FindTrustedKey(trustedKeys, signingKey.Public().(ed25519.PublicKey)) == nil) {
+		return SendResponse(&InitResponse{&TransferError{32, fmt.Sprintf("Uploads have to be signed with a key listed in %q. "+
+			"Create one using 'git-lfs-webdav manifest keygen' and configure it as lfs.webdav.signingKey", TrustedKeysFile)}}, writer)
 	}
 
-	createClient()
+	if signingKey != nil {
+		signingSession = remote.NewManifestSession(signingKey)
+	}
 
 	return SendResponse(&InitResponse{}, writer)
 }
 
 func processDownload(oid string, size int64, action *Action, writer *bufio.Writer) error {
-	basePath := strings.ReplaceAll(filepath.Join(oid[0:2], oid[2:4]), "\\", "/")
-	fullPath := strings.ReplaceAll(filepath.Join(basePath, oid), "\\", "/")
-
-	// Try to get some information of the remote file and do some consistency checks
-	remoteInfo, err := client.Stat(fullPath)
-	if err != nil && checkAuth(err) {
-		// If the credentials were changed retry the call
-		remoteInfo, err = client.Stat(fullPath)
+	if !ValidOid(oid) {
+		return SendTransferError(oid, 18, fmt.Sprintf("Invalid oid %q", oid), writer)
 	}
+
+	// With routing rules the object might be stored at any of the locations (see Router.Locations)
+	src, err := router.Locate(oid, size)
 	if err != nil {
-		return SendTransferError(oid, 5, fmt.Sprintf("Failed to stat remote file %q: %v", fullPath, err), writer)
+		return SendTransferError(oid, 5, fmt.Sprintf("Failed to look up object %s: %v", oid, err), writer)
 	}
 
-	if !remoteInfo.Mode().IsRegular() {
-		return SendTransferError(oid, 6, fmt.Sprintf("Remote file %q is not a regular file", fullPath), writer)
+	if src == nil {
+		src = router.Target(oid, size)
 	}
 
-	if remoteInfo.Size() != size {
-		return SendTransferError(oid, 7, fmt.Sprintf("Expected size %v but got %v for remote file %q", size, remoteInfo.Size(), fullPath), writer)
-	}
+	fullPath := src.Layout.ObjectPath(oid)
 
-	// Open the remote file
-	remoteReader, err := client.ReadStream(fullPath)
-	if err != nil && checkAuth(err) {
-		// If the credentials were changed retry the call
-		remoteReader, err = client.ReadStream(fullPath)
-	}
+	// Try to get some information of the remote file and do some consistency checks.
+	// Loose objects take precedence over packed ones so that repaired objects are used.
+	remoteInfo, err := src.StatObject(oid)
 	if err != nil {
-		return SendTransferError(oid, 8, fmt.Sprintf("Failed to read remote file %q: %v", fullPath, err), writer)
+		return SendTransferError(oid, 5, fmt.Sprintf("Failed to stat remote file %q: %v", fullPath, err), writer)
+	}
+
+	var remoteReader io.ReadCloser
+	if remoteInfo != nil {
+		if remoteInfo.IsDir {
+			return SendTransferError(oid, 6, fmt.Sprintf("Remote file %q is not a regular file", fullPath), writer)
+		}
+
+		if remoteInfo.Size != size {
+			return SendTransferError(oid, 7, fmt.Sprintf("Expected size %v but got %v for remote file %q", size, remoteInfo.Size, fullPath), writer)
+		}
+
+		// The checksum property (if the server stores it) detects misplaced objects without downloading them
+		if meta := remoteInfo.Meta; meta != nil && meta.SHA256 != oid {
+			return SendTransferError(oid, 30, fmt.Sprintf("Checksum property of remote file %q is %s", fullPath, meta.SHA256), writer)
+		}
+
+		// Open the remote file
+		remoteReader, err = src.ReadStream(fullPath)
+		if err != nil {
+			return SendTransferError(oid, 8, fmt.Sprintf("Failed to read remote file %q: %v", fullPath, err), writer)
+		}
+	} else {
+		// Small objects might be stored in a pack
+		entry, err := src.FindPacked(oid)
+		if err != nil {
+			return SendTransferError(oid, 20, fmt.Sprintf("Failed to read the pack indexes: %v", err), writer)
+		}
+
+		if entry == nil {
+			return SendTransferError(oid, 5, fmt.Sprintf("Remote file %q does not exist", fullPath), writer)
+		}
+
+		fullPath = src.PackDir() + "/" + entry.Pack + ".pack"
+
+		if entry.Size != size {
+			return SendTransferError(oid, 21, fmt.Sprintf("Expected size %v but got %v for packed object in %q", size, entry.Size, fullPath), writer)
+		}
+
+		// Read only the object from the pack
+		remoteReader, err = src.ReadPacked(entry)
+		if err != nil {
+			return SendTransferError(oid, 22, fmt.Sprintf("Failed to read packed object from %q: %v", fullPath, err), writer)
+		}
 	}
 
 	defer remoteReader.Close()
 
-	// Wrap the reader in a ProgressReader which will call the given function for every Read() call to report the progress
+	if trustedKeys != nil {
+		if manifest == nil {
+			manifest, err = remote.LoadManifest(trustedKeys)
+			if err != nil {
+				return SendTransferError(oid, 33, fmt.Sprintf("Failed to read the manifest: %v", err), writer)
+			}
+		}
+
+		if e, ok := manifest.Entries[oid]; !ok || e.Size != size {
+			return SendTransferError(oid, 34, fmt.Sprintf("Object %s is not listed in a manifest signed by a trusted key", oid), writer)
+		}
+	}
+
+	// Wrap the reader in a ProgressReader which will call the given function to report the progress
 	reader := &ProgressReader{Reader: remoteReader, ProgressFunc: func(bytesSoFar int64, bytesSinceLast int64) {
 		SendProgress(oid, bytesSoFar, bytesSinceLast, writer)
 	}}
@@ -184,20 +219,48 @@ func processDownload(oid string, size int64, action *Action, writer *bufio.Write
 		return SendTransferError(oid, 9, fmt.Sprintf("Failed to open local file %q: %v", tmpPath, err), writer)
 	}
 
-	defer file.Close()
+	// Incomplete downloads are removed (there is no resume which could use them)
+	complete := false
+	defer func() {
+		file.Close()
+		if !complete {
+			os.Remove(tmpPath)
+		}
+	}()
 
 	// Copy everything from the remote file into the local one
-	_, err = io.Copy(file, reader)
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), reader)
+	if err == nil {
+		err = file.Close()
+	}
 	if err != nil {
 		return SendTransferError(oid, 10, fmt.Sprintf("Failed to download remote file %q to local file %q: %v", fullPath, tmpPath, err), writer)
 	}
 
+	reader.Finish()
+	Tracef("Downloaded %s (%s) in %v at %s/s", oid, FormatSize(reader.Total()), reader.Duration().Round(time.Millisecond), FormatSize(int64(reader.Rate())))
+
+	// The manifest only protects the oid, so the content has to match it as well
+	if trustedKeys != nil && hex.EncodeToString(hash.Sum(nil)) != oid {
+		return SendTransferError(oid, 35, fmt.Sprintf("Content of remote file %q does not match its oid", fullPath), writer)
+	}
+
+	complete = true
 	return SendResponse(&TransferResponse{Event: "complete", Oid: oid, Path: tmpPath}, writer)
 }
 
 func processUpload(oid string, size int64, action *Action, path string, writer *bufio.Writer) error {
-	basePath := strings.ReplaceAll(filepath.Join(oid[0:2], oid[2:4]), "\\", "/")
-	fullPath := strings.ReplaceAll(filepath.Join(basePath, oid), "\\", "/")
+	if !ValidOid(oid) {
+		return SendTransferError(oid, 19, fmt.Sprintf("Invalid oid %q", oid), writer)
+	}
+
+	// Objects are uploaded to the remote chosen by the routing rules and the shards. Only that one is checked
+	// for an existing copy, copies at other locations are cleaned up by 'rebalance'.
+	dst := router.Target(oid, size)
+
+	basePath := dst.Layout.ObjectDir(oid)
+	fullPath := dst.Layout.ObjectPath(oid)
 
 	// Do some consistency checks on the given information
 	localInfo, err := os.Stat(path)
@@ -213,37 +276,46 @@ func processUpload(oid string, size int64, action *Action, path string, writer *
 		return SendTransferError(oid, 13, fmt.Sprintf("Expected size %v but got %v for local file %q", size, localInfo.Size(), path), writer)
 	}
 
-	// Get some information about the expected remote path (to check later whether it already exists)
-	remoteInfo, err := client.Stat(fullPath)
-	if err != nil && checkAuth(err) {
-		// If the credentials were changed retry the call
-		remoteInfo, err = client.Stat(fullPath)
-	}
+	// Get some information about the expected remote path (to check later whether it already exists).
+	// This uses the cached folder listings so that pushing many objects doesn't cost a request each.
+	remoteInfo, err := dst.StatObject(oid)
 	if err != nil {
-		if strings.Contains(err.Error(), "Not Found") {
-			// Ignore any Not Found errors
-			remoteInfo = nil
-			err = nil
-		} else {
-			return SendTransferError(oid, 14, fmt.Sprintf("Failed to stat remote file %q: %v", fullPath, err), writer)
-		}
+		return SendTransferError(oid, 14, fmt.Sprintf("Failed to stat remote file %q: %v", fullPath, err), writer)
 	}
 
 	// Check whether the file already exists with the expected size
-	if remoteInfo != nil && remoteInfo.Size() == size {
+	if remoteInfo != nil && remoteInfo.Size == size {
 		// In that case report the upload as complete without actually uploading something
 
 		SendProgress(oid, size, size, writer)
 
-		return SendResponse(&TransferResponse{Event: "complete", Oid: oid}, writer)
+		return uploadExisting(oid, size, path, writer)
+	}
+
+	// Content-addressed objects never change, so a different size means that one side is corrupt
+	if remoteInfo != nil && !repair {
+		return SendTransferError(oid, 27, fmt.Sprintf("Remote file %q exists with size %v instead of %v and is probably corrupt. "+
+			"Refusing to overwrite it, run 'git-lfs-webdav repair' to replace it", fullPath, remoteInfo.Size, size), writer)
 	}
 
-	// Create the required directory structure on the server
-	err = client.MkdirAll(basePath, 0644)
-	if err != nil && checkAuth(err) {
-		// If the credentials were changed retry the call
-		err = client.MkdirAll(basePath, 0644)
+	if remoteInfo != nil && remoteInfo.IsDir {
+		return SendTransferError(oid, 28, fmt.Sprintf("Remote file %q is not a regular file", fullPath), writer)
 	}
+
+	// Small objects might already be stored in a pack
+	entry, err := dst.FindPacked(oid)
+	if err != nil {
+		return SendTransferError(oid, 23, fmt.Sprintf("Failed to read the pack indexes: %v", err), writer)
+	}
+
+	if entry != nil && entry.Size == size {
+		SendProgress(oid, size, size, writer)
+
+		return uploadExisting(oid, size, path, writer)
+	}
+
+	// Create the required directory structure on the server (unless it is known to exist)
+	err = dst.EnsureDir(basePath)
 	if err != nil {
 		return SendTransferError(oid, 15, fmt.Sprintf("Failed to create remote folder %q: %v", basePath, err), writer)
 	}
@@ -256,31 +328,136 @@ func processUpload(oid string, size int64, action *Action, path string, writer *
 
 	defer file.Close()
 
-	// Wrap the file in a ProgressReader which will call the given function for every Read() call to report the progress
-	reader := &ProgressReader{Reader: file, ProgressFunc: func(bytesSoFar int64, bytesSinceLast int64) {
+	// Wrap the file in a ProgressReader which will call the given function to report the progress.
+	// The content is hashed on the way, so only objects which match their oid are signed.
+	hash := sha256.New()
+	reader := &ProgressReader{Reader: io.TeeReader(file, hash), ProgressFunc: func(bytesSoFar int64, bytesSinceLast int64) {
 		SendProgress(oid, bytesSoFar, bytesSinceLast, writer)
 	}}
 
-	// Write the remote file
-	err = client.WriteStream(fullPath, reader, 0644)
-	if err != nil && checkAuth(err) {
-		// If the credentials were changed retry the call
-		err = client.WriteStream(fullPath, reader, 0644)
+	// Write the remote file without overwriting anything that has been uploaded in the meantime
+	if remoteInfo == nil {
+		err = dst.CreateObject(oid, reader, size)
+	} else {
+		err = dst.ReplaceObject(oid, reader, size, remoteInfo.ETag)
+	}
+
+	if HasStatus(err, http.StatusPreconditionFailed) {
+		// Someone else has written the file, which is fine as long as it is complete
+		newInfo, err2 := dst.Stat(fullPath)
+		if err2 == nil && newInfo.Size == size {
+			return uploadExisting(oid, size, path, writer)
+		}
+
+		return SendTransferError(oid, 29, fmt.Sprintf("Remote file %q has been changed by someone else while uploading it", fullPath), writer)
 	}
+
 	if err != nil {
 		return SendTransferError(oid, 17, fmt.Sprintf("Failed to write remote file %q: %v", fullPath, err), writer)
 	}
 
+	reader.Finish()
+	Tracef("Uploaded %s (%s) in %v at %s/s", oid, FormatSize(reader.Total()), reader.Duration().Round(time.Millisecond), FormatSize(int64(reader.Rate())))
+
+	// Don't leave an object behind which doesn't match its oid
+	if hex.EncodeToString(hash.Sum(nil)) != oid {
+		dst.Delete(fullPath)
+		return SendTransferError(oid, 38, fmt.Sprintf("Content of local file %q does not match its oid", path), writer)
+	}
+
+	return uploadComplete(oid, size, writer)
+}
+
+// uploadExisting reports an object which is on the server already as complete. It is only signed
+// if the local file matches the oid, the signature must never cover content which hasn't been hashed.
+func uploadExisting(oid string, size int64, path string, writer *bufio.Writer) error {
+	if signingKey != nil {
+		file, err := os.Open(path)
+		if err != nil {
+			return SendTransferError(oid, 16, fmt.Sprintf("Failed to open local file %q: %v", path, err), writer)
+		}
+
+		err = VerifyObject(file, oid, size)
+		file.Close()
+		if err != nil {
+			return SendTransferError(oid, 38, fmt.Sprintf("Content of local file %q does not match its oid: %v", path, err), writer)
+		}
+	}
+
+	return uploadComplete(oid, size, writer)
+}
+
+// uploadComplete adds the object to the manifest (if a signing key is configured) and reports the upload as complete.
+// Git LFS only sends the next object after this, so every object is signed on its own until the session is closed.
+func uploadComplete(oid string, size int64, writer *bufio.Writer) error {
+	if signingSession != nil {
+		err := signingSession.Add(oid, size)
+		if err != nil {
+			return SendTransferError(oid, 37, fmt.Sprintf("Failed to add object %s to the manifest: %v", oid, err), writer)
+		}
+	}
+
 	return SendResponse(&TransferResponse{Event: "complete", Oid: oid}, writer)
 }
 
-// Processor processes the input
-func Processor() error {
-	scanner := bufio.NewScanner(os.Stdin)
+// Processor processes the input. In repair mode corrupt remote objects are overwritten.
+func Processor(repairMode bool) error {
+	repair = repairMode
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transferCtx = ctx
+
+	// SIGINT/SIGTERM cancel the requests in flight so that the agent can clean up and exit
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// stdin is read in the background so that waiting for the next request can be interrupted as well
+	lines := make(chan string)
+	scanErr := make(chan error, 1)
+
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		scanErr <- scanner.Err()
+		close(lines)
+	}()
+
 	writer := bufio.NewWriter(os.Stdout)
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	for {
+		var line string
+		var ok bool
+
+		select {
+		case line, ok = <-lines:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			return fmt.Errorf("Transfer interrupted")
+		}
+
+		if !ok {
+			break
+		}
 
 		var req Request
 		err := json.Unmarshal([]byte(line), &req)
@@ -290,24 +467,35 @@ func Processor() error {
 
 		switch req.Event {
 		case "init":
-			err := processInit(req.Operation, req.Remote, req.Concurrent, req.ConcurrentTransfers, writer)
-			if err != nil {
-				return err
-			}
+			err = processInit(req.Operation, req.Remote, req.Concurrent, req.ConcurrentTransfers, writer)
 		case "download":
-			err := processDownload(req.Oid, req.Size, req.Action, writer)
-			if err != nil {
-				return err
-			}
+			err = processDownload(req.Oid, req.Size, req.Action, writer)
 		case "upload":
-			err := processUpload(req.Oid, req.Size, req.Action, req.Path, writer)
-			if err != nil {
-				return err
-			}
+			err = processUpload(req.Oid, req.Size, req.Action, req.Path, writer)
 		case "terminate":
+			closeSigningSession()
 			return nil
 		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	closeSigningSession()
+
+	return <-scanErr
+}
+
+// closeSigningSession combines the manifest segments of this agent. The objects are signed already,
+// so a failure only leaves more segments behind and is not reported to Git LFS.
+func closeSigningSession() {
+	if signingSession == nil {
+		return
 	}
 
-	return scanner.Err()
+	err := signingSession.Close()
+	if err != nil {
+		Tracef("Failed to combine the manifest segments: %v", err)
+	}
 }
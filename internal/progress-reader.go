@@ -20,7 +20,16 @@
 package internal
 
 import (
+	"errors"
 	"io"
+	"time"
+)
+
+// Progress is reported at most every progressInterval unless progressBytes have been read in the meantime.
+// Reporting every Read would send hundreds of thousands of messages for large files.
+const (
+	progressInterval = 200 * time.Millisecond
+	progressBytes    = 16 << 20
 )
 
 // ProgressFunc is the progress callback function.
@@ -31,16 +40,94 @@ type ProgressReader struct {
 	io.Reader
 	ProgressFunc
 	total int64
+
+	reported   int64
+	start      time.Time
+	end        time.Time
+	lastReport time.Time
 }
 
 // Read 'overrides' the underlying io.Reader's Read method.
 func (pt *ProgressReader) Read(p []byte) (int, error) {
 	n, err := pt.Reader.Read(p)
 
-	sinceLast := int64(n)
-	pt.total += sinceLast
+	now := time.Now()
+	if pt.start.IsZero() {
+		pt.start = now
+		pt.lastReport = now
+	}
 
-	pt.ProgressFunc(pt.total, sinceLast)
+	pt.total += int64(n)
+
+	if err == io.EOF {
+		pt.end = now
+		pt.report(now)
+	} else if now.Sub(pt.lastReport) >= progressInterval || pt.total-pt.reported >= progressBytes {
+		pt.report(now)
+	}
 
 	return n, err
 }
+
+// Seek rewinds the underlying reader (if it is an io.Seeker) so a failed upload can be retried.
+// Bytes which are read again aren't reported a second time.
+func (pt *ProgressReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := pt.Reader.(io.Seeker)
+	if !ok {
+		return 0, errors.New("Reader is not seekable")
+	}
+
+	pos, err := seeker.Seek(offset, whence)
+	if err == nil {
+		pt.total = pos
+	}
+
+	return pos, err
+}
+
+func (pt *ProgressReader) report(now time.Time) {
+	if pt.total > pt.reported {
+		pt.ProgressFunc(pt.total, pt.total-pt.reported)
+		pt.reported = pt.total
+	}
+
+	pt.lastReport = now
+}
+
+// Finish reports the exact total (the reader might not have been read until io.EOF)
+func (pt *ProgressReader) Finish() {
+	now := time.Now()
+	if pt.end.IsZero() {
+		pt.end = now
+	}
+
+	pt.report(now)
+}
+
+// Total returns the number of bytes read so far
+func (pt *ProgressReader) Total() int64 {
+	return pt.total
+}
+
+// Duration returns the time from the first Read until the end (or until now)
+func (pt *ProgressReader) Duration() time.Duration {
+	if pt.start.IsZero() {
+		return 0
+	}
+
+	if pt.end.IsZero() {
+		return time.Since(pt.start)
+	}
+
+	return pt.end.Sub(pt.start)
+}
+
+// Rate returns the throughput in bytes per second
+func (pt *ProgressReader) Rate() float64 {
+	seconds := pt.Duration().Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+
+	return float64(pt.total) / seconds
+}
@@ -0,0 +1,155 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket which limits the throughput of all readers sharing it
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter for the given number of bytes per second (nil if the rate is 0)
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	// Allow bursts of up to 1/4 second so that the limit is smooth enough for interactive traffic
+	burst := float64(bytesPerSecond) / 4
+	if burst < 1024 {
+		burst = 1024
+	}
+
+	return &RateLimiter{rate: float64(bytesPerSecond), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Split divides the rate between n processes which have a limiter of their own each
+func (l *RateLimiter) Split(n int) {
+	if l == nil || n < 2 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate /= float64(n)
+	l.burst /= float64(n)
+	if l.burst < 1024 {
+		l.burst = 1024
+	}
+
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// ParseRate parses a rate like "5MB/s" or "500k" (see ParseSize)
+func ParseRate(s string) (int64, error) {
+	return ParseSize(strings.TrimSuffix(strings.TrimSpace(strings.ToLower(s)), "/s"))
+}
+
+// LFSConfigGetRate gets a rate setting (see ParseRate) and creates a limiter for it (nil if it is not set)
+func LFSConfigGetRate(name string) (*RateLimiter, error) {
+	value := LFSConfigGetOptional(name)
+	if len(value) < 1 {
+		return nil, nil
+	}
+
+	rate, err := ParseRate(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid value for %s: %v", name, err)
+	}
+
+	return NewRateLimiter(rate), nil
+}
+
+// wait takes n bytes from the bucket and blocks until they are available.
+// The bucket may go into debt, so concurrent readers are queued fairly without holding the lock while sleeping.
+func (l *RateLimiter) wait(n int) {
+	l.mu.Lock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// RateLimitedReader wraps an existing io.Reader and limits its throughput using a shared RateLimiter
+type RateLimitedReader struct {
+	io.Reader
+	Limiter *RateLimiter
+}
+
+// NewRateLimitedReader wraps the reader unless the limiter is nil
+func NewRateLimitedReader(reader io.Reader, limiter *RateLimiter) io.Reader {
+	if limiter == nil {
+		return reader
+	}
+
+	return &RateLimitedReader{reader, limiter}
+}
+
+// Read 'overrides' the underlying io.Reader's Read method.
+func (r *RateLimitedReader) Read(p []byte) (int, error) {
+	// Never read more than a burst at once, otherwise the transfer would stall and surge
+	if len(p) > int(r.Limiter.burst) {
+		p = p[:int(r.Limiter.burst)]
+	}
+
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.Limiter.wait(n)
+	}
+
+	return n, err
+}
+
+// Seek allows the request to be sent again after an authentication challenge if the underlying reader supports it
+func (r *RateLimitedReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := r.Reader.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("Reader is not seekable")
+	}
+
+	return seeker.Seek(offset, whence)
+}
@@ -0,0 +1,77 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import "testing"
+
+func TestParseRate(t *testing.T) {
+	tests := map[string]int64{
+		"500":     500,
+		"500k":    500 << 10,
+		"5MB/s":   5 << 20,
+		"5 mb/s":  5 << 20,
+		" 1GiB/S": 1 << 30,
+		"0":       0,
+	}
+
+	for s, expected := range tests {
+		rate, err := ParseRate(s)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", s, err)
+		} else if rate != expected {
+			t.Errorf("Expected %d for %q but got %d", expected, s, rate)
+		}
+	}
+
+	for _, s := range []string{"", "/s", "fast", "-5MB/s", "5MB/min"} {
+		_, err := ParseRate(s)
+		if err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
+
+func TestRateLimiterSplit(t *testing.T) {
+	if NewRateLimiter(0) != nil {
+		t.Error("Expected no limiter for a rate of 0")
+	}
+
+	// A nil limiter (no limit) can be split as well
+	var none *RateLimiter
+	none.Split(8)
+
+	l := NewRateLimiter(8 << 20)
+	l.Split(8)
+
+	if l.rate != 1<<20 {
+		t.Errorf("Expected a rate of %d but got %v", 1<<20, l.rate)
+	}
+
+	if l.burst != 1<<18 || l.tokens > l.burst {
+		t.Errorf("Expected a burst of %d but got %v (%v tokens)", 1<<18, l.burst, l.tokens)
+	}
+
+	l = NewRateLimiter(4096)
+	l.Split(8)
+
+	if l.rate != 512 || l.burst != 1024 {
+		t.Errorf("Expected a rate of 512 with a burst of 1024 but got %v and %v", l.rate, l.burst)
+	}
+}
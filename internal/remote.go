@@ -0,0 +1,679 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Remote is the WebDAV folder which stores the LFS objects
+type Remote struct {
+	URL    *url.URL
+	Layout *Layout
+
+	// Objects smaller than PackThreshold are stored in packs of up to PackSize bytes (0 disables packing).
+	// Only 'push --all' and 'repack' write packs, the transfer agent uploads every object loose.
+	PackThreshold int64
+	PackSize      int64
+
+	// Properties enables storing the metadata of uploaded objects as dead properties
+	Properties bool
+
+	// Ranges is false if the server doesn't support Range requests (see Probe), packed objects are read from the start then
+	Ranges bool
+
+	// The limits are shared by all transfers using this remote (nil if unlimited)
+	UploadLimit   *RateLimiter
+	DownloadLimit *RateLimiter
+
+	// authMu guards creds, which are fetched by whichever request gets the first 401 response
+	authMu sync.Mutex
+	creds  Creds
+	client *davClient
+
+	// All requests are cancelled once the context is done (see SetContext)
+	ctx context.Context
+
+	// Cached Depth:1 listings (folder -> path -> file) and folders known to exist on the server.
+	// They are kept for the whole session so that checking many objects doesn't cost a request each.
+	// mu is never held during a request, a folder which is being listed is marked in loading instead.
+	mu        sync.Mutex
+	listings  map[string]map[string]*RemoteFile
+	loading   map[string]chan struct{}
+	knownDirs map[string]bool
+
+	// packsMu serializes reading the pack indexes, which happens once per session
+	packsMu  sync.Mutex
+	packs    map[string]*PackEntry
+	uploader string
+}
+
+// GetLFSURL gets the configured LFS URL
+func GetLFSURL() (string, error) {
+	lfsURL, err := LFSConfigGet("lfs.url")
+	if err != nil {
+		return "", fmt.Errorf("Failed to get LFS URL: %v", err)
+	}
+
+	if len(lfsURL) < 1 {
+		return "", fmt.Errorf("Git LFS URL not configured!")
+	}
+
+	return lfsURL, nil
+}
+
+// ParseLFSURL parses the LFS URL and rewrites it back from webdav/webdavs to http/https
+// (this was done in cmd/init). Credentials are kept in the URL.
+func ParseLFSURL(lfsURL string) (*url.URL, error) {
+	baseURL, err := url.Parse(lfsURL)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse LFS URL %q: %v", lfsURL, err)
+	}
+
+	if baseURL.Scheme == "webdav" {
+		baseURL.Scheme = "http"
+	} else if baseURL.Scheme == "webdavs" {
+		baseURL.Scheme = "https"
+	}
+
+	return baseURL, nil
+}
+
+// NewRemote creates a remote for the given LFS URL using the configured layout
+func NewRemote(lfsURL string) (*Remote, error) {
+	baseURL, err := ParseLFSURL(lfsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := LoadLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	packThreshold, err := LFSConfigGetSize("lfs.webdav.packThreshold", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	packSize, err := LFSConfigGetSize("lfs.webdav.packSize", DefaultPackSize)
+	if err != nil {
+		return nil, err
+	}
+
+	properties, err := LFSConfigGetBool("lfs.webdav.properties", true)
+	if err != nil {
+		return nil, err
+	}
+
+	// The capabilities detected by 'init' (see Probe)
+	deadProperties, err := LFSConfigGetBool("lfs.webdav.deadProperties", true)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges, err := LFSConfigGetBool("lfs.webdav.ranges", true)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadLimit, err := LFSConfigGetRate("lfs.webdav.maxUploadRate")
+	if err != nil {
+		return nil, err
+	}
+
+	downloadLimit, err := LFSConfigGetRate("lfs.webdav.maxDownloadRate")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Remote{
+		URL:           baseURL,
+		ctx:           context.Background(),
+		Layout:        layout,
+		PackThreshold: packThreshold,
+		PackSize:      packSize,
+		Properties:    properties && deadProperties,
+		Ranges:        ranges,
+		UploadLimit:   uploadLimit,
+		DownloadLimit: downloadLimit,
+		listings:      make(map[string]map[string]*RemoteFile),
+		loading:       make(map[string]chan struct{}),
+		knownDirs:     map[string]bool{"": true},
+	}
+
+	// Use any credentials passed in the URL
+	if baseURL.User != nil {
+		username := baseURL.User.Username()
+		password, passwordSet := baseURL.User.Password()
+		baseURL.User = nil
+
+		r.creds = make(Creds)
+		r.creds["username"] = username
+		if passwordSet {
+			r.creds["password"] = password
+		}
+	}
+
+	r.createClient()
+
+	return r, nil
+}
+
+// OpenRemote creates a remote for the configured LFS URL
+func OpenRemote() (*Remote, error) {
+	lfsURL, err := GetLFSURL()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRemote(lfsURL)
+}
+
+// SetContext sets the context of all following requests. Cancelling it aborts the requests in flight.
+func (r *Remote) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+func (r *Remote) createClient() {
+	var username string
+	var password string
+
+	if r.creds != nil {
+		username = r.creds["username"]
+		password = r.creds["password"]
+	}
+
+	if r.client == nil {
+		r.client = newDavClient(r.URL, username, password)
+	} else {
+		r.client.setCredentials(username, password)
+	}
+}
+
+func (r *Remote) checkAuth(err error) bool {
+	if !HasStatus(err, http.StatusUnauthorized) {
+		return false
+	}
+
+	r.authMu.Lock()
+	defer r.authMu.Unlock()
+
+	// Parallel requests might have fetched the credentials in the meantime, which is worth a retry as well
+	if r.creds == nil {
+		// Then ask the git credential manager
+
+		creds := make(Creds)
+		creds["url"] = r.URL.String()
+
+		r.creds, _ = GitCredentialFill(creds)
+		if r.creds == nil {
+			// Fall back to the credentials stored by 'git-lfs-webdav login' if there is no credential helper
+			r.creds, err = LoadStoredCredentials(r.URL.String())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load the stored credentials: %v\n", err)
+			}
+		}
+		if r.creds != nil {
+			// If we got new credentials update the client
+			r.createClient()
+			return true
+		}
+
+		return false
+	}
+
+	// Every call retries only once, so wrong credentials only cost a single request
+	return true
+}
+
+// IsNotFound checks whether the given error was caused by a missing remote file
+func IsNotFound(err error) bool {
+	return HasStatus(err, http.StatusNotFound)
+}
+
+// Stat gets information about a remote file
+func (r *Remote) Stat(path string) (*RemoteFile, error) {
+	info, err := r.client.Stat(r.ctx, path)
+	if err != nil && r.checkAuth(err) {
+		// If the credentials were changed retry the call
+		info, err = r.client.Stat(r.ctx, path)
+	}
+
+	return info, err
+}
+
+// ReadDir lists a remote directory
+func (r *Remote) ReadDir(path string) ([]*RemoteFile, error) {
+	infos, err := r.client.ReadDir(r.ctx, path)
+	if err != nil && r.checkAuth(err) {
+		// If the credentials were changed retry the call
+		infos, err = r.client.ReadDir(r.ctx, path)
+	}
+
+	return infos, err
+}
+
+// ReadStream opens a remote file for reading
+func (r *Remote) ReadStream(path string) (io.ReadCloser, error) {
+	reader, err := r.client.ReadStream(r.ctx, path)
+	if err != nil && r.checkAuth(err) {
+		// If the credentials were changed retry the call
+		reader, err = r.client.ReadStream(r.ctx, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &readCloser{NewRateLimitedReader(reader, r.DownloadLimit), reader}, nil
+}
+
+// CreateStream writes a new remote file of the given size. It fails with 412 Precondition Failed
+// (see HasStatus) instead of overwriting the file if it exists already and the server supports conditional requests.
+// The parent folder has to exist already (see EnsureDir).
+func (r *Remote) CreateStream(p string, reader io.Reader, size int64) error {
+	_, err := r.createStream(p, reader, size)
+	return err
+}
+
+func (r *Remote) createStream(p string, reader io.Reader, size int64) (string, error) {
+	header := make(http.Header)
+	header.Set("If-None-Match", "*")
+
+	return r.writeStream(p, reader, size, header)
+}
+
+// ReplaceStream overwrites a remote file, but only if it still has the given ETag
+// (if the server didn't report an ETag the file is overwritten unconditionally)
+func (r *Remote) ReplaceStream(p string, reader io.Reader, size int64, etag string) error {
+	_, err := r.replaceStream(p, reader, size, etag)
+	return err
+}
+
+func (r *Remote) replaceStream(p string, reader io.Reader, size int64, etag string) (string, error) {
+	header := make(http.Header)
+	if len(etag) > 0 {
+		header.Set("If-Match", etag)
+	}
+
+	return r.writeStream(p, reader, size, header)
+}
+
+// writeStream uploads a file and returns its new ETag (empty if the server didn't send one)
+func (r *Remote) writeStream(p string, reader io.Reader, size int64, header http.Header) (string, error) {
+	etag, err := r.client.Put(r.ctx, p, NewRateLimitedReader(reader, r.UploadLimit), size, header)
+	if err != nil && r.checkAuth(err) && rewind(reader) {
+		// If the credentials were changed retry the call (the body has to be sent again, so only if it can be rewound)
+		etag, err = r.client.Put(r.ctx, p, NewRateLimitedReader(reader, r.UploadLimit), size, header)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	r.remember(&RemoteFile{Path: p, Size: size, ETag: etag})
+
+	return etag, nil
+}
+
+// EnsureDir creates a remote directory and all of its parents unless they are known to exist
+func (r *Remote) EnsureDir(dir string) error {
+	dir = strings.Trim(dir, "/")
+
+	r.mu.Lock()
+	known := r.knownDirs[dir]
+	r.mu.Unlock()
+
+	if known {
+		return nil
+	}
+
+	// The listing of the parent tells whether the folder exists already
+	info, err := r.lookup(dir)
+	if err != nil {
+		return err
+	}
+
+	if info == nil {
+		err = r.EnsureDir(parentDir(dir))
+		if err != nil {
+			return err
+		}
+
+		// Parallel uploads might create the same folder, which is fine since MKCOL of an existing folder is accepted
+		err = r.client.Mkcol(r.ctx, dir)
+		if err != nil && r.checkAuth(err) {
+			// If the credentials were changed retry the call
+			err = r.client.Mkcol(r.ctx, dir)
+		}
+		if err != nil && !HasStatus(err, http.StatusMethodNotAllowed) {
+			return err
+		}
+
+		r.mu.Lock()
+
+		// A new folder is empty and there is no need to ever list it
+		if _, ok := r.listings[dir]; !ok {
+			r.listings[dir] = make(map[string]*RemoteFile)
+		}
+		if listing, ok := r.listings[parentDir(dir)]; ok {
+			listing[dir] = &RemoteFile{Path: dir, IsDir: true}
+		}
+
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	r.knownDirs[dir] = true
+	r.mu.Unlock()
+
+	return nil
+}
+
+// StatObject gets information about the given object or nil if it doesn't exist.
+// The folders leading to the object are listed with a single Depth:1 PROPFIND each
+// and cached for the session, so checking many objects only costs a few requests.
+func (r *Remote) StatObject(oid string) (*RemoteFile, error) {
+	return r.lookup(r.Layout.ObjectPath(oid))
+}
+
+// ObjectSize returns the size of the given object stored either loose or in a pack or -1 if it doesn't exist
+func (r *Remote) ObjectSize(oid string) (int64, error) {
+	info, err := r.StatObject(oid)
+	if err != nil {
+		return 0, err
+	}
+
+	if info != nil {
+		return info.Size, nil
+	}
+
+	entry, err := r.FindPacked(oid)
+	if err != nil {
+		return 0, err
+	}
+
+	if entry != nil {
+		return entry.Size, nil
+	}
+
+	return -1, nil
+}
+
+// OpenObject opens an object stored either loose or in a pack for reading (nil if it doesn't exist).
+// It fails if the size or the checksum property of the remote file doesn't match.
+func (r *Remote) OpenObject(oid string, size int64) (io.ReadCloser, error) {
+	info, err := r.StatObject(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	if info != nil {
+		if info.IsDir {
+			return nil, fmt.Errorf("Remote file %q is not a regular file", info.Path)
+		}
+
+		if info.Size != size {
+			return nil, fmt.Errorf("Expected size %v but got %v for remote file %q", size, info.Size, info.Path)
+		}
+
+		if meta := info.Meta; meta != nil && meta.SHA256 != oid {
+			return nil, fmt.Errorf("Checksum property of remote file %q is %s", info.Path, meta.SHA256)
+		}
+
+		return r.ReadStream(info.Path)
+	}
+
+	entry, err := r.FindPacked(oid)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	if entry.Size != size {
+		return nil, fmt.Errorf("Expected size %v but got %v for packed object in %q", size, entry.Size, entry.Pack)
+	}
+
+	return r.ReadPacked(entry)
+}
+
+func (r *Remote) lookup(p string) (*RemoteFile, error) {
+	listing, err := r.listing(parentDir(p))
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return listing[p], nil
+}
+
+// ListDir returns a copy of the cached listing of a folder (path -> file), listing it on first use
+func (r *Remote) ListDir(dir string) (map[string]*RemoteFile, error) {
+	listing, err := r.listing(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := make(map[string]*RemoteFile, len(listing))
+	for p, info := range listing {
+		files[p] = info
+	}
+
+	return files, nil
+}
+
+// listing returns the cached listing of a folder. The map may only be accessed while holding r.mu.
+// Parallel callers wait for a folder which is being listed instead of listing it again.
+func (r *Remote) listing(dir string) (map[string]*RemoteFile, error) {
+	r.mu.Lock()
+	for {
+		if listing, ok := r.listings[dir]; ok {
+			r.mu.Unlock()
+			return listing, nil
+		}
+
+		wait, ok := r.loading[dir]
+		if !ok {
+			break
+		}
+
+		r.mu.Unlock()
+		<-wait
+		r.mu.Lock()
+	}
+
+	done := make(chan struct{})
+	r.loading[dir] = done
+	r.mu.Unlock()
+
+	listing, exists, err := r.readListing(dir)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.loading, dir)
+	close(done)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// EnsureDir might have created the folder in the meantime
+	if existing, ok := r.listings[dir]; ok {
+		return existing, nil
+	}
+
+	r.listings[dir] = listing
+	if exists {
+		r.knownDirs[dir] = true
+	}
+
+	return listing, nil
+}
+
+// readListing lists a folder with a single Depth:1 PROPFIND (an empty listing if it doesn't exist)
+func (r *Remote) readListing(dir string) (map[string]*RemoteFile, bool, error) {
+	listing := make(map[string]*RemoteFile)
+
+	// Only list folders that exist according to the listing of their parent
+	if len(dir) > 0 {
+		info, err := r.lookup(dir)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if info == nil || !info.IsDir {
+			return listing, false, nil
+		}
+	}
+
+	infos, err := r.ReadDir(dir)
+	if err != nil && !IsNotFound(err) {
+		return nil, false, fmt.Errorf("Failed to list remote folder %q: %v", dir, err)
+	}
+
+	for _, info := range infos {
+		listing[info.Path] = info
+	}
+
+	return listing, err == nil, nil
+}
+
+// remember adds a file written in this session to the cached listing of its folder
+func (r *Remote) remember(info *RemoteFile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if listing, ok := r.listings[parentDir(info.Path)]; ok {
+		listing[info.Path] = info
+	}
+}
+
+func parentDir(p string) string {
+	dir := path.Dir(p)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+
+	return dir
+}
+
+// Options asks the server which methods and WebDAV classes it supports for the given path
+func (r *Remote) Options(p string) (http.Header, error) {
+	header, err := r.client.Options(r.ctx, p)
+	if err != nil && r.checkAuth(err) {
+		// If the credentials were changed retry the call
+		header, err = r.client.Options(r.ctx, p)
+	}
+
+	return header, err
+}
+
+// HasCredentials reports whether credentials have been passed in the URL or fetched after a 401 response
+func (r *Remote) HasCredentials() bool {
+	r.authMu.Lock()
+	defer r.authMu.Unlock()
+
+	return r.creds != nil
+}
+
+// Delete removes a remote file (it is not an error if it doesn't exist)
+func (r *Remote) Delete(p string) error {
+	err := r.client.Delete(r.ctx, p)
+	if err != nil && r.checkAuth(err) {
+		// If the credentials were changed retry the call
+		err = r.client.Delete(r.ctx, p)
+	}
+	if err != nil && !IsNotFound(err) {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if listing, ok := r.listings[parentDir(p)]; ok {
+		delete(listing, p)
+	}
+
+	return nil
+}
+
+// Move moves a remote file without overwriting an existing destination
+func (r *Remote) Move(oldPath string, newPath string) error {
+	err := r.EnsureDir(parentDir(newPath))
+	if err != nil {
+		return err
+	}
+
+	err = r.client.Move(r.ctx, oldPath, newPath, false)
+	if err != nil && r.checkAuth(err) {
+		// If the credentials were changed retry the call
+		err = r.client.Move(r.ctx, oldPath, newPath, false)
+	}
+
+	return err
+}
+
+// WalkObjects calls fn for every object stored on the remote in the given layout
+func (r *Remote) WalkObjects(layout *Layout, fn func(oid string, info *RemoteFile) error) error {
+	return r.walk(layout.Root(), func(info *RemoteFile) error {
+		if oid, ok := layout.Matches(info.Path); ok {
+			return fn(oid, info)
+		}
+
+		return nil
+	})
+}
+
+func (r *Remote) walk(dir string, fn func(info *RemoteFile) error) error {
+	infos, err := r.ReadDir(dir)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil
+		}
+
+		return fmt.Errorf("Failed to list remote folder %q: %v", dir, err)
+	}
+
+	for _, info := range infos {
+		if info.IsDir {
+			err = r.walk(info.Path, fn)
+		} else {
+			err = fn(info)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
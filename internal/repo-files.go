@@ -0,0 +1,169 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// LFSConfigFile is the configuration file committed to the repository
+const LFSConfigFile = ".lfsconfig"
+
+// repoFile is the location of a committed file: a path in the working tree or a blob (both empty if it doesn't exist)
+type repoFile struct {
+	path string
+	blob string
+}
+
+var (
+	repoFilesMu sync.Mutex
+	repoFiles   = make(map[string]*repoFile)
+)
+
+// findRepoFile locates a committed file like Git LFS does for .lfsconfig: in the top level of the working tree
+// (which isn't the current folder in subfolders), otherwise in the index or in HEAD (e.g. in bare repositories)
+func findRepoFile(name string) *repoFile {
+	repoFilesMu.Lock()
+	defer repoFilesMu.Unlock()
+
+	if f, ok := repoFiles[name]; ok {
+		return f
+	}
+
+	f := &repoFile{}
+
+	topLevel, _ := GitGetTopLevel()
+	if len(topLevel) > 0 {
+		if _, err := os.Stat(filepath.Join(topLevel, name)); err == nil {
+			f.path = filepath.Join(topLevel, name)
+		}
+	}
+
+	if len(f.path) < 1 {
+		if GitRevExists(":" + name) {
+			f.blob = ":" + name
+		} else if GitRevExists("HEAD:" + name) {
+			f.blob = "HEAD:" + name
+		}
+	}
+
+	repoFiles[name] = f
+	return f
+}
+
+// ReadRepoFile reads a committed file (see findRepoFile). The error satisfies os.IsNotExist if it doesn't exist.
+func ReadRepoFile(name string) ([]byte, error) {
+	f := findRepoFile(name)
+
+	if len(f.path) > 0 {
+		return ioutil.ReadFile(f.path)
+	}
+
+	if len(f.blob) > 0 {
+		output, err := gitOutput(nil, "cat-file", "blob", f.blob)
+		return []byte(output), err
+	}
+
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+// RepoFileLocation describes where a committed file is read from (a path or a blob like "HEAD:.lfsconfig")
+func RepoFileLocation(name string) string {
+	f := findRepoFile(name)
+
+	if len(f.path) > 0 {
+		return f.path
+	}
+
+	return f.blob
+}
+
+// RepoFilePath returns the path of a file in the top level of the working tree, e.g. to write .lfsconfig
+func RepoFilePath(name string) (string, error) {
+	topLevel, err := GitGetTopLevel()
+	if err != nil {
+		return "", err
+	}
+
+	if len(topLevel) < 1 {
+		return "", fmt.Errorf("The repository has no working tree, so %s can't be written. Commit it from a clone instead.", name)
+	}
+
+	// Make sure that the file is read from the new location from now on
+	repoFilesMu.Lock()
+	delete(repoFiles, name)
+	repoFilesMu.Unlock()
+
+	return filepath.Join(topLevel, name), nil
+}
+
+// LFSConfigFileGet gets a setting from .lfsconfig only
+func LFSConfigFileGet(name string) (string, error) {
+	f := findRepoFile(LFSConfigFile)
+
+	if len(f.path) > 0 {
+		return GitConfigFileGet(f.path, name)
+	}
+
+	if len(f.blob) > 0 {
+		return GitConfigBlobGet(f.blob, name)
+	}
+
+	return "", fmt.Errorf("%s not found", LFSConfigFile)
+}
+
+// LFSConfigFileGetAll gets every value of a multi-valued setting from .lfsconfig only
+func LFSConfigFileGetAll(name string) []string {
+	f := findRepoFile(LFSConfigFile)
+
+	if len(f.path) > 0 {
+		return GitConfigGetAll(name, "-f", f.path)
+	}
+
+	if len(f.blob) > 0 {
+		return GitConfigGetAll(name, "--blob", f.blob)
+	}
+
+	return nil
+}
+
+// LFSConfigFileSet sets a setting in .lfsconfig in the top level of the working tree
+func LFSConfigFileSet(name string, value string) error {
+	p, err := RepoFilePath(LFSConfigFile)
+	if err != nil {
+		return err
+	}
+
+	return GitConfigFileSet(p, name, value)
+}
+
+// LFSConfigFileUnset removes a setting from .lfsconfig in the top level of the working tree
+func LFSConfigFileUnset(name string) error {
+	p, err := RepoFilePath(LFSConfigFile)
+	if err != nil {
+		return err
+	}
+
+	return GitConfigFileUnset(p, name)
+}
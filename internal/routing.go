@@ -0,0 +1,310 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Route sends objects of a certain size to a different WebDAV folder than lfs.url.
+// Routes are configured as "<op><size> <url>" using lfs.webdav.route (multiple times, the first matching one is used),
+// e.g. ">1GB webdavs://nas/lfs" or "<=64KB https://fast/lfs".
+type Route struct {
+	Op   string
+	Size int64
+	URL  string
+}
+
+var routeOps = []string{">=", "<=", ">", "<"}
+
+// ParseRoute parses a routing rule (see Route)
+func ParseRoute(s string) (*Route, error) {
+	value := strings.TrimSpace(s)
+
+	op := ""
+	for _, o := range routeOps {
+		if strings.HasPrefix(value, o) {
+			op = o
+			break
+		}
+	}
+
+	// The URL is the last field, everything between it and the operator is the size (e.g. "> 1 GB <url>")
+	fields := strings.Fields(strings.TrimPrefix(value, op))
+	if len(op) < 1 || len(fields) < 2 {
+		return nil, fmt.Errorf("Invalid route %q, expected '<op><size> <url>' like '>1GB webdavs://nas/lfs'", s)
+	}
+
+	size, err := ParseSize(strings.Join(fields[:len(fields)-1], ""))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid route %q: %v", s, err)
+	}
+
+	return &Route{Op: op, Size: size, URL: fields[len(fields)-1]}, nil
+}
+
+// Matches checks whether an object of the given size is sent to the route
+func (rt *Route) Matches(size int64) bool {
+	switch rt.Op {
+	case ">":
+		return size > rt.Size
+	case ">=":
+		return size >= rt.Size
+	case "<":
+		return size < rt.Size
+	case "<=":
+		return size <= rt.Size
+	}
+
+	return false
+}
+
+func (rt *Route) String() string {
+	return fmt.Sprintf("%s%s %s", rt.Op, FormatSize(rt.Size), rt.URL)
+}
+
+// LoadRoutes loads the routing rules (lfs.webdav.route) in order
+func LoadRoutes() ([]*Route, error) {
+	routes := make([]*Route, 0)
+
+	for _, value := range LFSConfigGetAll("lfs.webdav.route") {
+		route, err := ParseRoute(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value for lfs.webdav.route: %v", err)
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+// Router chooses the remote of every object using the routing rules by size and the shards.
+// Objects which no rule matches are stored on one of the shards, which are the primary remote (lfs.url)
+// and the additional shards (lfs.webdav.shard). The primary remote also stores the packs and the manifest.
+type Router struct {
+	Primary *Remote
+	Routes  []*Route
+	Shards  []*Remote
+
+	remotes []*Remote
+	ring    *ShardRing
+	byURL   map[string]*Remote
+}
+
+// NewRouter creates a router which sends objects to the shards (the primary remote and the given URLs)
+// unless one of the routes matches
+func NewRouter(primary *Remote, routes []*Route, shards []string) (*Router, error) {
+	rt := &Router{Primary: primary, Routes: routes, Shards: []*Remote{primary}, byURL: map[string]*Remote{primary.URL.String(): primary}}
+
+	names := []string{primary.URL.String()}
+	for _, shard := range shards {
+		r, err := rt.remote(shard)
+		if err != nil {
+			return nil, err
+		}
+
+		if containsRemote(rt.Shards, r) {
+			return nil, fmt.Errorf("Shard %s is configured twice", r.URL)
+		}
+
+		rt.Shards = append(rt.Shards, r)
+		names = append(names, r.URL.String())
+	}
+
+	rt.ring = NewShardRing(names)
+
+	for _, route := range routes {
+		r, err := rt.remote(route.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		rt.remotes = append(rt.remotes, r)
+	}
+
+	return rt, nil
+}
+
+// remote creates the remote for a URL. Routes and shards with the same URL share a remote (and its cached listings).
+func (rt *Router) remote(lfsURL string) (*Remote, error) {
+	u, err := ParseLFSURL(lfsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	u.User = nil
+	if r, ok := rt.byURL[u.String()]; ok {
+		return r, nil
+	}
+
+	r, err := NewRemote(lfsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// The bandwidth limits apply to all servers together
+	r.UploadLimit = rt.Primary.UploadLimit
+	r.DownloadLimit = rt.Primary.DownloadLimit
+
+	rt.byURL[r.URL.String()] = r
+	return r, nil
+}
+
+// OpenRouter creates a router for the configured LFS URL, routing rules and shards
+func OpenRouter() (*Router, error) {
+	primary, err := OpenRemote()
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := LoadRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRouter(primary, routes, LoadShards())
+}
+
+// SetContext sets the context of all remotes (see Remote.SetContext)
+func (rt *Router) SetContext(ctx context.Context) {
+	for _, r := range rt.Remotes() {
+		r.SetContext(ctx)
+	}
+}
+
+// Target returns the remote the given object is uploaded to
+func (rt *Router) Target(oid string, size int64) *Remote {
+	for i, route := range rt.Routes {
+		if route.Matches(size) {
+			return rt.remotes[i]
+		}
+	}
+
+	return rt.Shards[rt.ring.Lookup(oid)]
+}
+
+// RouteRemote returns the remote of the i-th route
+func (rt *Router) RouteRemote(i int) *Remote {
+	return rt.remotes[i]
+}
+
+// Locations returns the remotes which might store the given object: the target first, then the remotes
+// of the other routes in order and the shards last (objects stay where they are if the rules or the shards
+// are changed until they are moved by 'rebalance')
+func (rt *Router) Locations(oid string, size int64) []*Remote {
+	locations := []*Remote{rt.Target(oid, size)}
+
+	for _, r := range append(append([]*Remote{}, rt.remotes...), rt.Shards...) {
+		if !containsRemote(locations, r) {
+			locations = append(locations, r)
+		}
+	}
+
+	return locations
+}
+
+// Remotes returns every remote starting with the shards
+func (rt *Router) Remotes() []*Remote {
+	remotes := append([]*Remote{}, rt.Shards...)
+
+	for _, r := range rt.remotes {
+		if !containsRemote(remotes, r) {
+			remotes = append(remotes, r)
+		}
+	}
+
+	return remotes
+}
+
+// Locate returns the remote which stores the object with the given size (nil if there is none)
+func (rt *Router) Locate(oid string, size int64) (*Remote, error) {
+	for _, r := range rt.Locations(oid, size) {
+		s, err := r.ObjectSize(oid)
+		if err != nil {
+			return nil, err
+		}
+
+		if s == size {
+			return r, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ObjectSize works like Remote.ObjectSize for all locations. If the object has the wrong size
+// at every location which stores it the size at the first one is returned.
+func (rt *Router) ObjectSize(oid string, size int64) (int64, error) {
+	found := int64(-1)
+
+	for _, r := range rt.Locations(oid, size) {
+		s, err := r.ObjectSize(oid)
+		if err != nil {
+			return 0, err
+		}
+
+		if s == size {
+			return s, nil
+		}
+
+		if s >= 0 && found < 0 {
+			found = s
+		}
+	}
+
+	return found, nil
+}
+
+// OpenObject opens the object at the first location which stores it (see Remote.OpenObject)
+func (rt *Router) OpenObject(oid string, size int64) (io.ReadCloser, error) {
+	r, err := rt.Locate(oid, size)
+	if err != nil || r == nil {
+		return nil, err
+	}
+
+	return r.OpenObject(oid, size)
+}
+
+// Authenticate requests the root folder of every remote so that credentials are fetched before transfers run in parallel
+func (rt *Router) Authenticate() error {
+	for _, r := range rt.Remotes() {
+		_, err := r.Stat("")
+		if err != nil && !HasStatus(err, http.StatusNotFound) {
+			return fmt.Errorf("%s: %v", r.URL, err)
+		}
+	}
+
+	return nil
+}
+
+func containsRemote(remotes []*Remote, r *Remote) bool {
+	for _, other := range remotes {
+		if other == r {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import "testing"
+
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		spec string
+		op   string
+		size int64
+		url  string
+	}{
+		{">1GB webdavs://nas/lfs", ">", 1 << 30, "webdavs://nas/lfs"},
+		{"<=64KB https://fast/lfs", "<=", 64 << 10, "https://fast/lfs"},
+		{">= 512 http://host/lfs", ">=", 512, "http://host/lfs"},
+		{" < 1.5 MB  https://host/lfs ", "<", 3 << 19, "https://host/lfs"},
+	}
+
+	for _, test := range tests {
+		route, err := ParseRoute(test.spec)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", test.spec, err)
+			continue
+		}
+
+		if route.Op != test.op || route.Size != test.size || route.URL != test.url {
+			t.Errorf("Unexpected route %+v for %q", route, test.spec)
+		}
+	}
+
+	for _, spec := range []string{"", "1GB https://host/lfs", ">1GB", "https://host/lfs", "=1GB https://host/lfs", ">big https://host/lfs"} {
+		_, err := ParseRoute(spec)
+		if err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestRouteMatches(t *testing.T) {
+	tests := []struct {
+		op      string
+		size    int64
+		matches bool
+	}{
+		{">", 99, false}, {">", 100, false}, {">", 101, true},
+		{">=", 99, false}, {">=", 100, true}, {">=", 101, true},
+		{"<", 99, true}, {"<", 100, false}, {"<", 101, false},
+		{"<=", 99, true}, {"<=", 100, true}, {"<=", 101, false},
+		{"==", 100, false},
+	}
+
+	for _, test := range tests {
+		route := &Route{Op: test.op, Size: 100, URL: "https://host/lfs"}
+		if route.Matches(test.size) != test.matches {
+			t.Errorf("Expected %s100 to match %d: %v", test.op, test.size, test.matches)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// shardReplicas is the number of points of every shard on the ring. More points spread the objects more evenly.
+const shardReplicas = 128
+
+// ShardRing assigns objects to shards using consistent hashing: every shard owns the sections of the ring
+// in front of its points and the position of an object is its oid prefix. Adding a shard only moves the
+// objects of the sections it takes over (about 1/n of them) instead of reshuffling everything.
+type ShardRing struct {
+	points []uint64
+	shards []int
+}
+
+// NewShardRing creates a ring for the shards with the given names (their URLs). The position of a shard only
+// depends on its name, so the order of the shards doesn't matter.
+func NewShardRing(names []string) *ShardRing {
+	ring := &ShardRing{}
+
+	type point struct {
+		hash  uint64
+		shard int
+	}
+
+	points := make([]point, 0, len(names)*shardReplicas)
+	for i, name := range names {
+		for n := 0; n < shardReplicas; n++ {
+			sum := sha256.Sum256([]byte(fmt.Sprintf("%s#%d", name, n)))
+			points = append(points, point{binary.BigEndian.Uint64(sum[:8]), i})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	for _, p := range points {
+		ring.points = append(ring.points, p.hash)
+		ring.shards = append(ring.shards, p.shard)
+	}
+
+	return ring
+}
+
+// Lookup returns the index of the shard storing the given object (the first point at or after its position)
+func (ring *ShardRing) Lookup(oid string) int {
+	if len(ring.points) < 1 || len(oid) < 16 {
+		return 0
+	}
+
+	// The oid is a SHA-256 hash already, so its first 8 bytes are evenly distributed
+	prefix, err := hex.DecodeString(oid[:16])
+	if err != nil {
+		return 0
+	}
+
+	position := binary.BigEndian.Uint64(prefix)
+
+	i := sort.Search(len(ring.points), func(i int) bool {
+		return ring.points[i] >= position
+	})
+	if i == len(ring.points) {
+		i = 0
+	}
+
+	return ring.shards[i]
+}
+
+// LoadShards loads the URLs of the additional shards (lfs.webdav.shard), lfs.url is always the first shard
+func LoadShards() []string {
+	return LFSConfigGetAll("lfs.webdav.shard")
+}
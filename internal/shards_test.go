@@ -0,0 +1,97 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func testOids(n int) []string {
+	oids := make([]string, n)
+	for i := range oids {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("object %d", i)))
+		oids[i] = hex.EncodeToString(sum[:])
+	}
+
+	return oids
+}
+
+func TestShardRingStable(t *testing.T) {
+	names := []string{"https://a/lfs/", "https://b/lfs/", "https://c/lfs/"}
+	ring := NewShardRing(names)
+	again := NewShardRing(names)
+
+	counts := make([]int, len(names))
+	for _, oid := range testOids(3000) {
+		shard := ring.Lookup(oid)
+		if shard != again.Lookup(oid) {
+			t.Fatalf("Lookup of %s is not stable", oid)
+		}
+
+		counts[shard]++
+	}
+
+	// Every shard gets a reasonable share of the objects
+	for i, count := range counts {
+		if count < 500 {
+			t.Errorf("Shard %d only got %d of 3000 objects", i, count)
+		}
+	}
+}
+
+func TestShardRingAddShard(t *testing.T) {
+	before := NewShardRing([]string{"https://a/lfs/", "https://b/lfs/", "https://c/lfs/"})
+	after := NewShardRing([]string{"https://a/lfs/", "https://b/lfs/", "https://c/lfs/", "https://d/lfs/"})
+
+	oids := testOids(4000)
+	moved := 0
+
+	for _, oid := range oids {
+		old, shard := before.Lookup(oid), after.Lookup(oid)
+		if old == shard {
+			continue
+		}
+
+		// Objects only move to the new shard, never between the existing ones
+		if shard != 3 {
+			t.Fatalf("Object %s moved from shard %d to %d", oid, old, shard)
+		}
+
+		moved++
+	}
+
+	if moved < len(oids)/8 || moved > len(oids)/2 {
+		t.Errorf("Expected about a quarter of the objects to move but %d of %d did", moved, len(oids))
+	}
+}
+
+func TestShardRingSingle(t *testing.T) {
+	ring := NewShardRing([]string{"https://a/lfs/"})
+	empty := NewShardRing(nil)
+
+	for _, oid := range append(testOids(100), "", "abc", "not a hex oid at all, but long enough") {
+		if ring.Lookup(oid) != 0 || empty.Lookup(oid) != 0 {
+			t.Errorf("Expected shard 0 for %q", oid)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Tracef writes a debug message like git does if GIT_TRACE is set ("1", "true" or "2" for stderr,
+// an absolute path to append to a file). The output of the transfer agent is shown by 'GIT_TRACE=1 git lfs ...'.
+func Tracef(format string, args ...interface{}) {
+	target := os.Getenv("GIT_TRACE")
+	toStderr := target == "1" || target == "2" || strings.ToLower(target) == "true"
+
+	if !toStderr && !filepath.IsAbs(target) {
+		return
+	}
+
+	line := fmt.Sprintf("%s git-lfs-webdav: %s\n", time.Now().Format("15:04:05.000000"), fmt.Sprintf(format, args...))
+
+	if !toStderr {
+		file, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			return
+		}
+
+		defer file.Close()
+
+		file.WriteString(line)
+		return
+	}
+
+	os.Stderr.WriteString(line)
+}
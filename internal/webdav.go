@@ -0,0 +1,724 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// StatusError is returned if the server responds with an unexpected status code
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %q failed with: %s", e.Method, "/"+e.Path, e.Status)
+}
+
+// HasStatus checks whether the given error is a StatusError with the given status code
+func HasStatus(err error, statusCode int) bool {
+	var serr *StatusError
+	return errors.As(err, &serr) && serr.StatusCode == statusCode
+}
+
+// RemoteFile describes a file or folder on the remote
+type RemoteFile struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	ETag    string
+	IsDir   bool
+
+	// Meta is nil unless the server stores dead properties and the file has been annotated
+	Meta *ObjectMeta
+}
+
+// Name returns the last element of the path
+func (f *RemoteFile) Name() string {
+	return path.Base(f.Path)
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Status string  `xml:"DAV: status"`
+	Prop   davProp `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength string `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+	ETag          string `xml:"DAV: getetag"`
+
+	// Dead properties in propNamespace
+	SHA256     string `xml:"https://github.com/mpotthoff/git-lfs-webdav sha256"`
+	Uploader   string `xml:"https://github.com/mpotthoff/git-lfs-webdav uploader"`
+	Uploaded   string `xml:"https://github.com/mpotthoff/git-lfs-webdav uploaded"`
+	Version    string `xml:"https://github.com/mpotthoff/git-lfs-webdav version"`
+	UploadETag string `xml:"https://github.com/mpotthoff/git-lfs-webdav etag"`
+}
+
+// propfindBody requests the dead properties in propNamespace, which gowebdav doesn't support.
+// Servers return unknown properties with 404 Not Found, so they can always be requested.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:l="` + propNamespace + `">
+	<d:prop>
+		<d:resourcetype/>
+		<d:getcontentlength/>
+		<d:getlastmodified/>
+		<d:getetag/>
+		<l:sha256/>
+		<l:uploader/>
+		<l:uploaded/>
+		<l:version/>
+		<l:etag/>
+	</d:prop>
+</d:propfind>`
+
+// davClient sends the WebDAV requests. Stat and GET use gowebdav. The other requests are sent directly, either because
+// gowebdav doesn't support them (listings with dead properties, conditional PUT, Range, PROPPATCH, OPTIONS, MOVE and
+// COPY) or because it reports transport errors and cancellation as 400 Bad Request (MKCOL and DELETE).
+type davClient struct {
+	root   *url.URL
+	client *http.Client
+
+	mu       sync.Mutex
+	username string
+	password string
+	scheme   string
+	digest   map[string]string
+	nc       int
+
+	// gowebdav clients aren't safe for concurrent use, so every request borrows an idle one
+	idle []*pooledClient
+}
+
+// pooledClient is a gowebdav client which keeps the authentication negotiated by its first request
+type pooledClient struct {
+	*gowebdav.Client
+	transport *contextTransport
+	username  string
+	password  string
+}
+
+// contextTransport attaches the context of the current request because gowebdav doesn't support contexts
+type contextTransport struct {
+	ctx context.Context
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return http.DefaultTransport.RoundTrip(req.WithContext(t.ctx))
+}
+
+func newDavClient(root *url.URL, username string, password string) *davClient {
+	u := *root
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+
+	return &davClient{root: &u, client: &http.Client{}, username: username, password: password}
+}
+
+func (c *davClient) setCredentials(username string, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.username = username
+	c.password = password
+	c.idle = nil
+}
+
+// acquire borrows a gowebdav client for a single request
+func (c *davClient) acquire(ctx context.Context) *pooledClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var pc *pooledClient
+	if len(c.idle) > 0 {
+		pc = c.idle[len(c.idle)-1]
+		c.idle = c.idle[:len(c.idle)-1]
+	} else {
+		pc = &pooledClient{Client: gowebdav.NewClient(c.root.String(), c.username, c.password), transport: &contextTransport{},
+			username: c.username, password: c.password}
+		pc.SetTransport(pc.transport)
+	}
+
+	pc.transport.ctx = ctx
+	return pc
+}
+
+// release returns a client unless the credentials have been changed in the meantime
+func (c *davClient) release(pc *pooledClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if pc.username == c.username && pc.password == c.password {
+		c.idle = append(c.idle, pc)
+	}
+}
+
+// davError converts the errors of gowebdav, which only contain the status code as text, into a StatusError
+func davError(method string, p string, err error) error {
+	var perr *os.PathError
+	if !errors.As(err, &perr) {
+		return err
+	}
+
+	// Either only the code ("404") or the status line ("404 Not Found - PROPFIND /path")
+	fields := strings.Fields(perr.Err.Error())
+	if len(fields) > 0 {
+		if code, cerr := strconv.Atoi(fields[0]); cerr == nil && code >= 100 && code < 600 {
+			return &StatusError{method, p, code, fmt.Sprintf("%d %s", code, http.StatusText(code))}
+		}
+	}
+
+	return err
+}
+
+func (c *davClient) url(p string) *url.URL {
+	u := *c.root
+	u.Path = c.root.Path + strings.TrimPrefix(p, "/")
+	return &u
+}
+
+// relative converts a href returned by the server into a path relative to the root
+func (c *davClient) relative(href string) string {
+	u, err := url.Parse(href)
+	if err == nil {
+		href = u.Path
+	}
+
+	return strings.Trim(strings.TrimPrefix(href, c.root.Path), "/")
+}
+
+func (c *davClient) authorize(req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.username) < 1 && len(c.password) < 1 {
+		return
+	}
+
+	switch c.scheme {
+	case "basic":
+		req.SetBasicAuth(c.username, c.password)
+	case "digest":
+		c.nc++
+		req.Header.Set("Authorization", digestAuthorization(c.digest, c.username, c.password, req.Method, req.URL.RequestURI(), c.nc))
+	}
+}
+
+// challenge remembers the authentication scheme requested by the server and reports whether a retry makes sense
+func (c *davClient) challenge(resp *http.Response) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := resp.Header.Get("Www-Authenticate")
+	lower := strings.ToLower(header)
+
+	previous := c.scheme
+	if strings.HasPrefix(lower, "digest") {
+		c.scheme = "digest"
+		c.digest = parseChallenge(header[len("digest"):])
+		c.nc = 0
+	} else if strings.HasPrefix(lower, "basic") {
+		c.scheme = "basic"
+	} else {
+		return false
+	}
+
+	// Retrying only helps if there are credentials which haven't been sent in this form yet
+	return (len(c.username) > 0 || len(c.password) > 0) && (previous != c.scheme || c.scheme == "digest" && strings.Contains(lower, "stale=true"))
+}
+
+// do sends a request and handles the authentication.
+// The body is only sent a second time (after an authentication challenge) if it can be rewound.
+func (c *davClient) do(ctx context.Context, method string, p string, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.url(p).String(), body)
+		if err != nil {
+			return nil, err
+		}
+
+		// Tell the server the size upfront instead of using chunked encoding
+		if body != nil && size >= 0 {
+			req.ContentLength = size
+		}
+
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		c.authorize(req)
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			return resp, nil
+		}
+
+		drain(resp)
+
+		if attempt > 0 || !c.challenge(resp) || !rewind(body) {
+			return nil, &StatusError{method, p, resp.StatusCode, resp.Status}
+		}
+	}
+}
+
+func rewind(body io.Reader) bool {
+	if body == nil {
+		return true
+	}
+
+	if seeker, ok := body.(io.Seeker); ok {
+		_, err := seeker.Seek(0, io.SeekStart)
+		return err == nil
+	}
+
+	return false
+}
+
+func drain(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func expect(method string, p string, resp *http.Response, statusCodes ...int) error {
+	for _, statusCode := range statusCodes {
+		if resp.StatusCode == statusCode {
+			return nil
+		}
+	}
+
+	return &StatusError{method, p, resp.StatusCode, resp.Status}
+}
+
+// Propfind lists the properties of the given path (depth 0) or of its children (depth 1)
+func (c *davClient) Propfind(ctx context.Context, p string, depth int, body string) (*davMultistatus, error) {
+	header := make(http.Header)
+	header.Set("Depth", strconv.Itoa(depth))
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := c.do(ctx, "PROPFIND", p, header, strings.NewReader(body), -1)
+	if err != nil {
+		return nil, err
+	}
+
+	defer drain(resp)
+
+	err = expect("PROPFIND", p, resp, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := &davMultistatus{}
+	err = xml.NewDecoder(resp.Body).Decode(ms)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse PROPFIND response of %q: %v", "/"+p, err)
+	}
+
+	return ms, nil
+}
+
+func (c *davClient) files(ms *davMultistatus) []*RemoteFile {
+	files := make([]*RemoteFile, 0, len(ms.Responses))
+
+	for _, r := range ms.Responses {
+		var f *RemoteFile
+
+		// The found properties might be split over several propstat elements
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200") {
+				continue
+			}
+
+			if f == nil {
+				f = &RemoteFile{Path: c.relative(r.Href)}
+			}
+
+			prop := &ps.Prop
+			if prop.ResourceType.Collection != nil {
+				f.IsDir = true
+			}
+			if len(prop.ContentLength) > 0 {
+				f.Size, _ = strconv.ParseInt(prop.ContentLength, 10, 64)
+			}
+			if len(prop.LastModified) > 0 {
+				f.ModTime, _ = http.ParseTime(prop.LastModified)
+			}
+			if len(prop.ETag) > 0 {
+				f.ETag = prop.ETag
+			}
+			if len(prop.SHA256) > 0 {
+				f.Meta = &ObjectMeta{SHA256: prop.SHA256, Uploader: prop.Uploader, Version: prop.Version, UploadETag: prop.UploadETag}
+				f.Meta.Uploaded, _ = time.Parse(time.RFC3339, prop.Uploaded)
+			}
+		}
+
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+
+	return files
+}
+
+// Stat gets information about a single file or folder
+func (c *davClient) Stat(ctx context.Context, p string) (*RemoteFile, error) {
+	pc := c.acquire(ctx)
+	defer c.release(pc)
+
+	info, err := pc.Stat("/" + p)
+	if err != nil {
+		return nil, davError("PROPFIND", p, err)
+	}
+
+	// gowebdav returns a nil *File if the response didn't contain the properties
+	file, ok := info.(*gowebdav.File)
+	if !ok || file == nil {
+		return nil, &StatusError{"PROPFIND", p, http.StatusNotFound, "404 Not Found"}
+	}
+
+	return remoteFile(strings.Trim(p, "/"), file), nil
+}
+
+// ReadDir lists the contents of a folder using a single Depth:1 PROPFIND. The listing includes the dead properties,
+// so the metadata of the objects doesn't cost a request per object.
+func (c *davClient) ReadDir(ctx context.Context, p string) ([]*RemoteFile, error) {
+	p = strings.Trim(p, "/")
+
+	// Some servers redirect folders without a trailing slash
+	dir := p
+	if len(dir) > 0 {
+		dir += "/"
+	}
+
+	ms, err := c.Propfind(ctx, dir, 1, propfindBody)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := c.files(ms)
+	files := make([]*RemoteFile, 0, len(infos))
+	for _, info := range infos {
+		// The response contains the folder itself as well
+		if info.Path != p {
+			files = append(files, info)
+		}
+	}
+
+	return files, nil
+}
+
+func remoteFile(p string, file *gowebdav.File) *RemoteFile {
+	return &RemoteFile{Path: p, Size: file.Size(), ModTime: file.ModTime(), ETag: file.ETag(), IsDir: file.IsDir()}
+}
+
+// Properties gets the dead properties of a single file (nil if it hasn't been annotated)
+func (c *davClient) Properties(ctx context.Context, p string) (*ObjectMeta, error) {
+	ms, err := c.Propfind(ctx, p, 0, propfindBody)
+	if err != nil {
+		return nil, err
+	}
+
+	files := c.files(ms)
+	if len(files) < 1 {
+		return nil, &StatusError{"PROPFIND", p, http.StatusNotFound, "404 Not Found"}
+	}
+
+	return files[0].Meta, nil
+}
+
+// ReadStream opens a remote file for reading
+func (c *davClient) ReadStream(ctx context.Context, p string) (io.ReadCloser, error) {
+	pc := c.acquire(ctx)
+	defer c.release(pc)
+
+	reader, err := pc.ReadStream(p)
+	if err != nil {
+		return nil, davError("GET", p, err)
+	}
+
+	return reader, nil
+}
+
+// Get opens a remote file for reading
+func (c *davClient) Get(ctx context.Context, p string, header http.Header) (*http.Response, error) {
+	resp, err := c.do(ctx, "GET", p, header, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	err = expect("GET", p, resp, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		drain(resp)
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Put writes a remote file and returns its new ETag (if the server sends one)
+func (c *davClient) Put(ctx context.Context, p string, body io.Reader, size int64, header http.Header) (string, error) {
+	resp, err := c.do(ctx, "PUT", p, header, body, size)
+	if err != nil {
+		return "", err
+	}
+
+	defer drain(resp)
+
+	err = expect("PUT", p, resp, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Header.Get("ETag"), nil
+}
+
+// Proppatch sets the given dead properties in propNamespace. It fails unless all of them have been stored.
+func (c *davClient) Proppatch(ctx context.Context, p string, props map[string]string) error {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	buf.WriteString(`<d:propertyupdate xmlns:d="DAV:" xmlns:l="` + propNamespace + `"><d:set><d:prop>`)
+	for _, name := range names {
+		buf.WriteString("<l:" + name + ">")
+		xml.EscapeText(buf, []byte(props[name]))
+		buf.WriteString("</l:" + name + ">")
+	}
+	buf.WriteString(`</d:prop></d:set></d:propertyupdate>`)
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := c.do(ctx, "PROPPATCH", p, header, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		return err
+	}
+
+	defer drain(resp)
+
+	err = expect("PROPPATCH", p, resp, http.StatusMultiStatus)
+	if err != nil {
+		return err
+	}
+
+	ms := &davMultistatus{}
+	err = xml.NewDecoder(resp.Body).Decode(ms)
+	if err != nil {
+		return fmt.Errorf("Failed to parse PROPPATCH response of %q: %v", "/"+p, err)
+	}
+
+	// Every property has its own status (e.g. 403 Forbidden if dead properties are not supported).
+	// The others fail with 424 Failed Dependency then, so that is only returned if there is no other error.
+	var failed error
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			fields := strings.Fields(ps.Status)
+			if len(fields) < 2 || fields[1] != "200" {
+				code := 0
+				if len(fields) > 1 {
+					code, _ = strconv.Atoi(fields[1])
+				}
+
+				failed = &StatusError{"PROPPATCH", p, code, strings.Join(fields[1:], " ")}
+				if code != http.StatusFailedDependency {
+					return failed
+				}
+			}
+		}
+	}
+
+	return failed
+}
+
+// Options returns the headers of an OPTIONS request (e.g. DAV and Allow)
+func (c *davClient) Options(ctx context.Context, p string) (http.Header, error) {
+	resp, err := c.do(ctx, "OPTIONS", p, nil, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	defer drain(resp)
+
+	err = expect("OPTIONS", p, resp, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Header, nil
+}
+
+// Mkcol creates a single remote folder (servers answer 405 Method Not Allowed if it exists already)
+func (c *davClient) Mkcol(ctx context.Context, p string) error {
+	// Some servers redirect folders without a trailing slash
+	resp, err := c.do(ctx, "MKCOL", strings.TrimSuffix(p, "/")+"/", nil, nil, -1)
+	if err != nil {
+		return err
+	}
+
+	defer drain(resp)
+
+	return expect("MKCOL", p, resp, http.StatusCreated)
+}
+
+// Delete removes a remote file or folder (it is not an error if it doesn't exist)
+func (c *davClient) Delete(ctx context.Context, p string) error {
+	resp, err := c.do(ctx, "DELETE", p, nil, nil, -1)
+	if err != nil {
+		return err
+	}
+
+	defer drain(resp)
+
+	return expect("DELETE", p, resp, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+}
+
+// Move moves a remote file using WebDAV MOVE (gowebdav can't be used because it panics if the request fails)
+func (c *davClient) Move(ctx context.Context, src string, dst string, overwrite bool) error {
+	return c.copyMove(ctx, "MOVE", src, dst, overwrite)
+}
+
+// Copy copies a remote file using WebDAV COPY
+func (c *davClient) Copy(ctx context.Context, src string, dst string, overwrite bool) error {
+	return c.copyMove(ctx, "COPY", src, dst, overwrite)
+}
+
+func (c *davClient) copyMove(ctx context.Context, method string, src string, dst string, overwrite bool) error {
+	header := make(http.Header)
+	header.Set("Destination", c.url(dst).String())
+	if overwrite {
+		header.Set("Overwrite", "T")
+	} else {
+		header.Set("Overwrite", "F")
+	}
+
+	resp, err := c.do(ctx, method, src, header, nil, -1)
+	if err != nil {
+		return err
+	}
+
+	defer drain(resp)
+
+	return expect(method, src, resp, http.StatusCreated, http.StatusNoContent)
+}
+
+func parseChallenge(s string) map[string]string {
+	params := make(map[string]string)
+
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.Index(s, ","); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+
+		params[key] = strings.TrimSpace(value)
+	}
+
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func digestAuthorization(params map[string]string, username string, password string, method string, uri string, nc int) string {
+	ha1 := md5Hex(username + ":" + params["realm"] + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=MD5`, username, params["realm"], params["nonce"], uri)
+
+	if qop := params["qop"]; len(qop) > 0 {
+		cnonceBytes := make([]byte, 8)
+		rand.Read(cnonceBytes)
+		cnonce := hex.EncodeToString(cnonceBytes)
+		count := fmt.Sprintf("%08x", nc)
+
+		response := md5Hex(ha1 + ":" + params["nonce"] + ":" + count + ":" + cnonce + ":auth:" + ha2)
+		header += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s", response="%s"`, count, cnonce, response)
+	} else {
+		header += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+params["nonce"]+":"+ha2))
+	}
+
+	if opaque, ok := params["opaque"]; ok {
+		header += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+
+	return header
+}
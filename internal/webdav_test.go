@@ -0,0 +1,257 @@
+/**
+ * Copyright (c) 2020 Michael Potthoff
+ *
+ * This file is part of git-lfs-webdav.
+ *
+ * git-lfs-webdav is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * git-lfs-webdav is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with git-lfs-webdav. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	params := parseChallenge(` realm="test realm", nonce="abc,def", qop="auth,auth-int", algorithm=MD5, stale=true`)
+
+	expected := map[string]string{
+		"realm":     "test realm",
+		"nonce":     "abc,def",
+		"qop":       "auth,auth-int",
+		"algorithm": "MD5",
+		"stale":     "true",
+	}
+
+	for key, value := range expected {
+		if params[key] != value {
+			t.Errorf("Expected %s=%q but got %q", key, value, params[key])
+		}
+	}
+}
+
+// checkDigest verifies an Authorization header the way a server would
+func checkDigest(header string, realm string, nonce string, username string, password string, method string) bool {
+	if !strings.HasPrefix(header, "Digest ") {
+		return false
+	}
+
+	params := parseChallenge(header[len("Digest "):])
+	if params["username"] != username || params["realm"] != realm || params["nonce"] != nonce {
+		return false
+	}
+
+	ha1 := md5Hex(username + ":" + realm + ":" + password)
+	ha2 := md5Hex(method + ":" + params["uri"])
+
+	var response string
+	if len(params["qop"]) > 0 {
+		response = md5Hex(ha1 + ":" + nonce + ":" + params["nc"] + ":" + params["cnonce"] + ":" + params["qop"] + ":" + ha2)
+	} else {
+		response = md5Hex(ha1 + ":" + nonce + ":" + ha2)
+	}
+
+	return params["response"] == response
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	params := map[string]string{"realm": "r", "nonce": "n", "opaque": "o"}
+
+	header := digestAuthorization(params, "user", "secret", "GET", "/lfs/file", 1)
+	if !checkDigest(header, "r", "n", "user", "secret", "GET") {
+		t.Errorf("Invalid response without qop: %s", header)
+	}
+
+	if !strings.Contains(header, `opaque="o"`) {
+		t.Errorf("Opaque is missing: %s", header)
+	}
+
+	params["qop"] = "auth"
+
+	header = digestAuthorization(params, "user", "secret", "PUT", "/lfs/file", 2)
+	if !checkDigest(header, "r", "n", "user", "secret", "PUT") {
+		t.Errorf("Invalid response with qop: %s", header)
+	}
+
+	if !strings.Contains(header, "nc=00000002") {
+		t.Errorf("Unexpected nonce count: %s", header)
+	}
+
+	if checkDigest(header, "r", "n", "user", "wrong", "PUT") {
+		t.Errorf("Response matches a wrong password: %s", header)
+	}
+}
+
+func TestDigestRequest(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if !checkDigest(req.Header.Get("Authorization"), "lfs", "12345", "user", "secret", req.Method) {
+			w.Header().Set("WWW-Authenticate", `Digest realm="lfs", nonce="12345", qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	root, _ := url.Parse(server.URL + "/lfs")
+
+	client := newDavClient(root, "user", "secret")
+	_, err := client.Put(context.Background(), "objects/file", strings.NewReader("content"), 7, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 2 {
+		t.Errorf("Expected a challenge and a retry but got %d requests", requests)
+	}
+
+	// The following requests are authorized upfront
+	_, err = client.Put(context.Background(), "objects/file", strings.NewReader("content"), 7, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 3 {
+		t.Errorf("Expected a single request but got %d", requests-2)
+	}
+
+	client = newDavClient(root, "user", "wrong")
+	_, err = client.Put(context.Background(), "objects/file", strings.NewReader("content"), 7, nil)
+	if !HasStatus(err, http.StatusUnauthorized) {
+		t.Errorf("Expected 401 Unauthorized but got %v", err)
+	}
+}
+
+func TestDavError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{&os.PathError{Op: "ReadDir", Path: "objects", Err: errors.New("404")}, 404},
+		{&os.PathError{Op: "Stat", Path: "objects", Err: errors.New("403 Forbidden - PROPFIND /objects")}, 403},
+		{&os.PathError{Op: "Stat", Path: "objects", Err: errors.New("connection refused")}, 0},
+		{errors.New("404"), 0},
+	}
+
+	for _, test := range tests {
+		err := davError("PROPFIND", "objects", test.err)
+
+		var serr *StatusError
+		if errors.As(err, &serr) {
+			if serr.StatusCode != test.status {
+				t.Errorf("Expected status %d for %q but got %d", test.status, test.err, serr.StatusCode)
+			}
+		} else if test.status != 0 {
+			t.Errorf("Expected status %d for %q but got %v", test.status, test.err, err)
+		} else if err != test.err {
+			t.Errorf("Expected %q to be returned unchanged but got %v", test.err, err)
+		}
+	}
+}
+
+func TestProppatchStatus(t *testing.T) {
+	var statuses []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(`<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>/lfs/file</d:href>`))
+		for _, status := range statuses {
+			w.Write([]byte(`<d:propstat><d:prop/><d:status>HTTP/1.1 ` + status + `</d:status></d:propstat>`))
+		}
+		w.Write([]byte(`</d:response></d:multistatus>`))
+	}))
+	defer server.Close()
+
+	root, _ := url.Parse(server.URL + "/lfs")
+	client := newDavClient(root, "", "")
+	props := map[string]string{"sha256": "abc", "version": "1"}
+
+	tests := []struct {
+		statuses []string
+		status   int
+	}{
+		{[]string{"200 OK"}, 0},
+		{[]string{"424 Failed Dependency", "403 Forbidden"}, http.StatusForbidden},
+		{[]string{"200 OK", "424 Failed Dependency"}, http.StatusFailedDependency},
+		{[]string{"507 Insufficient Storage"}, http.StatusInsufficientStorage},
+	}
+
+	for _, test := range tests {
+		statuses = test.statuses
+
+		err := client.Proppatch(context.Background(), "file", props)
+		if test.status == 0 && err != nil {
+			t.Errorf("Expected no error for %v but got %v", test.statuses, err)
+		} else if test.status != 0 && !HasStatus(err, test.status) {
+			t.Errorf("Expected status %d for %v but got %v", test.status, test.statuses, err)
+		}
+	}
+}
+
+func TestMkcolDelete(t *testing.T) {
+	status := http.StatusCreated
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	root, _ := url.Parse(server.URL + "/lfs")
+	client := newDavClient(root, "", "")
+
+	err := client.Mkcol(context.Background(), "objects")
+	if err != nil {
+		t.Errorf("Expected no error but got %v", err)
+	}
+
+	status = http.StatusMethodNotAllowed
+	err = client.Mkcol(context.Background(), "objects")
+	if !HasStatus(err, http.StatusMethodNotAllowed) {
+		t.Errorf("Expected 405 Method Not Allowed but got %v", err)
+	}
+
+	status = http.StatusNotFound
+	err = client.Delete(context.Background(), "objects/file")
+	if err != nil {
+		t.Errorf("Expected no error for a missing file but got %v", err)
+	}
+
+	status = http.StatusForbidden
+	err = client.Delete(context.Background(), "objects/file")
+	if !HasStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 Forbidden but got %v", err)
+	}
+
+	// Cancellation must not be reported as a status
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = client.Mkcol(ctx, "objects")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancellation but got %v", err)
+	}
+
+	err = client.Delete(ctx, "objects/file")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancellation but got %v", err)
+	}
+}
@@ -34,19 +34,86 @@ func main() {
 
 	var err error = nil
 	switch command {
+	case "bundle":
+		err = cmd.Bundle(os.Args[2:])
+	case "clone":
+		err = cmd.Clone(os.Args[2:])
+	case "doctor":
+		err = cmd.Doctor(os.Args[2:])
+	case "fetch":
+		err = cmd.Fetch(os.Args[2:])
 	case "init":
 		err = cmd.Init(os.Args[2:])
+	case "install":
+		err = cmd.Install(os.Args[2:])
 	case "login":
 		err = cmd.Login(os.Args[2:])
+	case "logout":
+		err = cmd.Logout(os.Args[2:])
+	case "manifest":
+		err = cmd.Manifest(os.Args[2:])
+	case "push":
+		err = cmd.Push(os.Args[2:])
+	case "rebalance":
+		err = cmd.Rebalance(os.Args[2:])
+	case "relayout":
+		err = cmd.Relayout(os.Args[2:])
+	case "repack":
+		err = cmd.Repack(os.Args[2:])
+	case "repair":
+		err = cmd.Repair(os.Args[2:])
+	case "stats":
+		err = cmd.Stats(os.Args[2:])
+	case "status":
+		err = cmd.Status(os.Args[2:])
 	case "transfer":
 		err = cmd.Transfer(os.Args[2:])
+	case "uninit":
+		err = cmd.Uninit(os.Args[2:])
+	case "uninstall":
+		err = cmd.Uninstall(os.Args[2:])
+	case "verify-push":
+		err = cmd.VerifyPush(os.Args[2:])
 	case "version":
 		err = cmd.Version(os.Args[2:])
 	default:
 		usage := `Usage:
-    git-lfs-webdav init [url]  Initialize LFS WebDAV for the git repository in the current working directory.
-    git-lfs-webdav login       Save login credentials in case the git credential manager does not work.
+    git-lfs-webdav bundle <create <file> <refs...> | import [--local] <file>>
+                               Move LFS objects without a network connection (alongside 'git bundle').
+    git-lfs-webdav clone <repository> [directory]
+                               Clone a repository, configure LFS WebDAV and download the LFS files.
+    git-lfs-webdav doctor      Check the configuration and the connection to the server.
+    git-lfs-webdav fetch [--jobs <n>] [--all | [--recent <n>] [refs...]]
+                               Download the LFS objects of the given refs in parallel without checking them out.
+    git-lfs-webdav init [--no-verify] [url]
+                               Initialize LFS WebDAV for the git repository in the current working directory.
+    git-lfs-webdav install --global --match <host-or-url>
+                               Use LFS WebDAV for all repositories with a matching URL without running init.
+    git-lfs-webdav login [--passphrase]
+                               Store login credentials in the git credential helper or an encrypted file.
+    git-lfs-webdav logout      Remove the stored login credentials.
+    git-lfs-webdav manifest <keygen [path] | pubkey | sign [refs...] | verify [refs...]>
+                               Manage the signed manifest of the uploaded objects.
+    git-lfs-webdav push [--jobs <n>] --all
+                               Upload every object of the local LFS storage which is missing on the server.
+    git-lfs-webdav rebalance [--dry-run] [--jobs <n>] [--drain <url>...]
+                               Move objects to the servers chosen by the shards and routing rules.
+    git-lfs-webdav relayout <layout> [prefix]
+                               Move all objects on the server to a different layout.
+    git-lfs-webdav repack      Combine packs and small objects on the server and drop unreferenced entries.
+    git-lfs-webdav repair [--quick] [--dry-run] [refs...]
+                               Re-upload missing or corrupt objects on the server from the local LFS storage.
+    git-lfs-webdav stats [--json] [--top <n>]
+                               Report the number, size and growth of the objects on the server.
+    git-lfs-webdav status [--verbose] [refs...]
+                               Compare the local LFS objects and the objects of the refs with the server.
     git-lfs-webdav transfer    Called internally by git-lfs.
+    git-lfs-webdav uninit [--lfsconfig] [--credentials] [--dry-run]
+                               Remove the configuration of init (and the URL and credentials) from the repository.
+    git-lfs-webdav uninstall --global [--match <host-or-url>]
+                               Remove the global configuration of install.
+    git-lfs-webdav verify-push [--install | --hook] [remote [refs...]]
+                               Check that all LFS objects of the commits to push exist on the server.
     git-lfs-webdav version     Report the version number and exit.
 `
 